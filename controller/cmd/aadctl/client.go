@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type client struct {
+	address    string
+	token      string
+	httpClient *http.Client
+}
+
+func newClient(address, token string) *client {
+	return &client{
+		address:    strings.TrimSuffix(address, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// do sends a request to the admin api and decodes the response into out, if it is not nil.
+func (c *client) do(method, path string, body, out any) error {
+	res, err := c.send(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// lines sends a get request to the admin api and calls f with each json value of the response.
+func (c *client) lines(path string, f func(json.RawMessage) error) error {
+	res, err := c.send(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	d := json.NewDecoder(res.Body)
+	for d.More() {
+		var v json.RawMessage
+		err = d.Decode(&v)
+		if err != nil {
+			return err
+		}
+		err = f(v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends a request to the admin api, and returns the response if it is successful.
+func (c *client) send(method, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.address+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&e)
+		if e.Error == "" {
+			e.Error = res.Status
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, e.Error)
+	}
+	return res, nil
+}
@@ -0,0 +1,452 @@
+// Command aadctl operates the controller through its admin api.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/admin"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: aadctl [flags] <command> [args]
+
+Commands:
+  state              show the current limit, replicas and bans
+  report [-window duration]
+                     show the latest monitor report, or the aggregate of the reports in the window
+  bans               list banned IPs
+  ban [-for duration] [-reason text] <ip>
+                     ban an IP, permanently if no duration is given
+  unban <ip>         unban an IP
+  limit <limit>      override the rate limit
+  adaptations [-f]   list executed adaptations, -f keeps tailing new ones
+  decisions [-f]     list the analyzer's decisions, as json lines with -o json
+  pause [duration]   pause automatic adaptation, indefinitely if no duration is given
+  resume             resume automatic adaptation
+  override [-limit n] [-replicas n] [-for duration]
+                     pin the limit and/or replicas, bans stay automatic
+
+Flags:
+`
+
+type cli struct {
+	client *client
+	output string
+	out    io.Writer
+}
+
+func main() {
+	flags := flag.NewFlagSet("aadctl", flag.ExitOnError)
+	address := flags.String("addr", envOr("AAD_ADDR", "http://localhost:6042"), "admin api address, or $AAD_ADDR")
+	token := flags.String("token", os.Getenv("AAD_TOKEN"), "admin api token, or $AAD_TOKEN")
+	output := flags.String("o", "table", "output format, table or json")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *output != "table" && *output != "json" {
+		fail(fmt.Errorf("unknown output format %q", *output))
+	}
+
+	c := &cli{
+		client: newClient(*address, *token),
+		output: *output,
+		out:    os.Stdout,
+	}
+	err := c.run(flags.Arg(0), flags.Args()[1:])
+	if err != nil {
+		fail(err)
+	}
+}
+
+func (c *cli) run(command string, args []string) error {
+	switch command {
+	case "state":
+		return c.state()
+	case "report":
+		return c.report(args)
+	case "bans":
+		return c.bans()
+	case "ban":
+		return c.ban(args)
+	case "unban":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: aadctl unban <ip>")
+		}
+		return c.do(http.MethodDelete, "/bans/"+url.PathEscape(args[0]), nil)
+	case "limit":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: aadctl limit <limit>")
+		}
+		limit, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid limit: %w", err)
+		}
+		return c.do(http.MethodPut, "/limit", map[string]int{"limit": limit})
+	case "adaptations":
+		follow := len(args) == 1 && args[0] == "-f"
+		return c.adaptations(follow)
+	case "decisions":
+		follow := len(args) == 1 && args[0] == "-f"
+		return c.decisions(follow)
+	case "pause":
+		body := map[string]string{}
+		if len(args) == 1 {
+			body["duration"] = args[0]
+		}
+		return c.setMode(http.MethodPost, "/pause", body)
+	case "resume":
+		return c.setMode(http.MethodPost, "/resume", nil)
+	case "override":
+		return c.override(args)
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// do sends a request and prints its response as is.
+func (c *cli) do(method, path string, body any) error {
+	var res map[string]any
+	err := c.client.do(method, path, body, &res)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.printJSON(res)
+	}
+	keys := make([]string, 0, len(res))
+	for k := range res {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tw := c.table()
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s\t%v\n", strings.ToUpper(k), res[k])
+	}
+	return tw.Flush()
+}
+
+func (c *cli) state() error {
+	var s admin.State
+	err := c.client.do(http.MethodGet, "/state", nil, &s)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.printJSON(s)
+	}
+	tw := c.table()
+	fmt.Fprintf(tw, "LIMIT\t%d\n", s.Limit)
+	fmt.Fprintf(tw, "REPLICAS\t%d\n", s.Replicas)
+	fmt.Fprintf(tw, "PENDING LIMIT CHANGE\t%t\n", s.PendingLimitChange)
+	fmt.Fprintf(tw, "PENDING REPLICA CHANGE\t%t\n", s.PendingReplicaChange)
+	fmt.Fprintf(tw, "MODE\t%s\n", s.Mode)
+	fmt.Fprintf(tw, "BANNED IPS\t%d\n", len(s.BannedIPs))
+	return tw.Flush()
+}
+
+func (c *cli) ban(args []string) error {
+	flags := flag.NewFlagSet("ban", flag.ContinueOnError)
+	duration := flags.Duration("for", 0, "duration of the ban, permanent if zero")
+	reason := flags.String("reason", "", "reason of the ban")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: aadctl ban [-for duration] [-reason text] <ip>")
+	}
+
+	body := map[string]string{"ip": flags.Arg(0), "reason": *reason}
+	if *duration != 0 {
+		body["duration"] = duration.String()
+	}
+	return c.do(http.MethodPost, "/bans", body)
+}
+
+func (c *cli) override(args []string) error {
+	flags := flag.NewFlagSet("override", flag.ContinueOnError)
+	limit := flags.Int("limit", 0, "pinned limit")
+	replicas := flags.Int("replicas", 0, "pinned replicas")
+	duration := flags.Duration("for", 0, "duration of the override, indefinitely if zero")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]any{"limit": *limit, "replicas": *replicas}
+	if *duration != 0 {
+		body["duration"] = duration.String()
+	}
+	return c.do(http.MethodPut, "/override", body)
+}
+
+func (c *cli) setMode(method, path string, body any) error {
+	var m knowledge.Mode
+	err := c.client.do(method, path, body, &m)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.printJSON(m)
+	}
+	_, err = fmt.Fprintln(c.out, "mode:", m)
+	return err
+}
+
+func (c *cli) report(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	window := flags.Duration("window", 0, "aggregate the reports of this window, the latest report if zero")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("usage: aadctl report [-window duration]")
+	}
+	if *window > 0 {
+		return c.reportAggregate(*window)
+	}
+
+	var r monitor.Report
+	err = c.client.do(http.MethodGet, "/report", nil, &r)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.printJSON(r)
+	}
+	tw := c.table()
+	fmt.Fprintf(tw, "TIME\t%s\n", formatTime(r.Time))
+	fmt.Fprintf(tw, "CPU UTILIZATION\t%.3f\n", r.AverageCpuUtilization)
+	fmt.Fprintf(tw, "RUNNING REPLICAS\t%d\n", r.RunningReplicas)
+	fmt.Fprintf(tw, "TOTAL RATE\t%.2f\n", r.Requests.TotalRate)
+	fmt.Fprintf(tw, "NON LIMITED RATE\t%.2f\n", r.Requests.NonLimitedRate)
+	fmt.Fprintf(tw, "GOOD LATENCY PERCENT\t%.3f\n", r.Requests.GoodLatencyPercent)
+	fmt.Fprintf(tw, "POTENTIAL ATTACKERS\t%d\n", len(r.PotentialAttackerIPs))
+	err = tw.Flush()
+	if err != nil || len(r.IPStats) == 0 {
+		return err
+	}
+
+	// the addresses with the highest rate first
+	ips := make([]string, 0, len(r.IPStats))
+	for ip := range r.IPStats {
+		ips = append(ips, ip)
+	}
+	sort.Slice(ips, func(a, b int) bool {
+		ra, rb := r.IPStats[ips[a]].TotalRate, r.IPStats[ips[b]].TotalRate
+		return ra > rb || ra == rb && ips[a] < ips[b]
+	})
+	fmt.Fprintln(c.out)
+	tw = c.table()
+	fmt.Fprintln(tw, "IP\tTOTAL RATE\tLIMITED RATE\tNOT FOUND RATE\tPOTENTIAL ATTACKER")
+	for _, ip := range ips {
+		st := r.IPStats[ip]
+		_, attacker := r.PotentialAttackerIPs[ip]
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%.2f\t%t\n", ip, st.TotalRate, st.LimitedRate, st.NotFoundRate, attacker)
+	}
+	return tw.Flush()
+}
+
+func (c *cli) reportAggregate(window time.Duration) error {
+	var a admin.ReportAggregate
+	err := c.client.do(http.MethodGet, "/reports/aggregate?window="+url.QueryEscape(window.String()), nil, &a)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.printJSON(a)
+	}
+	if a.Reports == 0 {
+		_, err = fmt.Fprintf(c.out, "no reports in the last %s\n", a.Window)
+		return err
+	}
+	_, err = fmt.Fprintf(c.out, "%d reports from %s to %s\n", a.Reports, formatTime(a.From), formatTime(a.To))
+	if err != nil {
+		return err
+	}
+	tw := c.table()
+	fmt.Fprintln(tw, "METRIC\tCOUNT\tMEAN\tMIN\tMAX")
+	for _, m := range []struct {
+		name string
+		a    admin.Aggregate
+	}{
+		{"cpu_utilization", a.CpuUtilization},
+		{"total_rate", a.TotalRate},
+		{"non_limited_rate", a.NonLimitedRate},
+		{"good_latency_percent", a.GoodLatencyPercent},
+	} {
+		fmt.Fprintf(tw, "%s\t%d\t%.3f\t%.3f\t%.3f\n", m.name, m.a.Count, m.a.Mean, m.a.Min, m.a.Max)
+	}
+	return tw.Flush()
+}
+
+func (c *cli) bans() error {
+	var bans []admin.Ban
+	err := c.client.do(http.MethodGet, "/bans", nil, &bans)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.printJSON(bans)
+	}
+	tw := c.table()
+	fmt.Fprintln(tw, "IP\tSOURCE\tBANNED AT\tEXPIRES AT\tREASON")
+	for _, b := range bans {
+		expires := "never"
+		if b.ExpiresAt != nil {
+			expires = formatTime(*b.ExpiresAt)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", b.IP, b.Source, formatTime(b.BannedAt), expires, b.Reason)
+	}
+	return tw.Flush()
+}
+
+func (c *cli) adaptations(follow bool) error {
+	var since time.Time
+	header := true
+	for {
+		var adaptations []admin.Adaptation
+		path := "/adaptations"
+		if !since.IsZero() {
+			path += "?since=" + url.QueryEscape(since.Format(time.RFC3339Nano))
+		}
+		err := c.client.do(http.MethodGet, path, nil, &adaptations)
+		if err != nil {
+			return err
+		}
+		for _, a := range adaptations {
+			since = a.Time
+		}
+
+		if c.output == "json" {
+			for _, a := range adaptations {
+				err = c.printJSON(a)
+				if err != nil {
+					return err
+				}
+			}
+		} else {
+			tw := c.table()
+			if header {
+				fmt.Fprintln(tw, "TIME\tLIMIT\tREPLICAS\tBANNED\tUNBANNED\tDRY RUN")
+				header = false
+			}
+			for _, a := range adaptations {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\n", formatTime(a.Time), formatInt(a.Limit), formatInt(a.Replicas),
+					formatList(a.Banned), formatList(a.Unbanned), a.DryRun)
+			}
+			err = tw.Flush()
+			if err != nil {
+				return err
+			}
+		}
+
+		if !follow {
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func (c *cli) table() *tabwriter.Writer {
+	return tabwriter.NewWriter(c.out, 0, 0, 3, ' ', 0)
+}
+
+func (c *cli) decisions(follow bool) error {
+	var since time.Time
+	header := true
+	for {
+		path := "/decisions"
+		if !since.IsZero() {
+			path += "?since=" + url.QueryEscape(since.Format(time.RFC3339Nano))
+		}
+		tw := c.table()
+		if header && c.output != "json" {
+			fmt.Fprintln(tw, "TIME\tMODE\tPOLICY\tCPU\tLIMIT\tREPLICAS\tBANNED\tREASON")
+			header = false
+		}
+		err := c.client.lines(path, func(v json.RawMessage) error {
+			var d knowledge.Decision
+			err := json.Unmarshal(v, &d)
+			if err != nil {
+				return err
+			}
+			since = d.Time
+			if c.output == "json" {
+				// the records are written as they are, one per line
+				_, err = fmt.Fprintf(c.out, "%s\n", v)
+				return err
+			}
+			_, err = fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f\t%s\t%s\t%s\t%s\n", formatTime(d.Time), d.Mode, d.Policy,
+				d.Inputs.CpuUtilization, formatInt(d.Limit), formatInt(d.Replicas), formatList(d.Banned), d.Reason)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		err = tw.Flush()
+		if err != nil {
+			return err
+		}
+
+		if !follow {
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func (c *cli) printJSON(v any) error {
+	e := json.NewEncoder(c.out)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
+
+func formatTime(t time.Time) string {
+	return t.Local().Format(time.DateTime)
+}
+
+func formatInt(v int) string {
+	if v == 0 {
+		return "-"
+	}
+	return strconv.Itoa(v)
+}
+
+func formatList(l []string) string {
+	if len(l) == 0 {
+		return "-"
+	}
+	return strings.Join(l, ",")
+}
+
+func envOr(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "aadctl:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,311 @@
+// Command simulate runs the MAPE-K loop against a simulated service on a virtual clock,
+// and writes a time series of the adaptation decisions and their effects.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/analyze"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/execute"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/plan"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"time"
+)
+
+type options struct {
+	configPath   string
+	scenarioPath string
+	duration     time.Duration
+	step         time.Duration
+	pollInterval time.Duration
+	replicas     int
+	capacity     float64
+	startup      time.Duration
+	format       string
+	decisions    string
+	traefikAPI   bool
+	stallAt      time.Duration
+	stallFor     time.Duration
+	verbose      bool
+}
+
+func main() {
+	var o options
+	flag.StringVar(&o.configPath, "config", "", "controller config file, defaults are used if empty")
+	flag.StringVar(&o.scenarioPath, "scenario", "loadtest/config.yaml", "traffic scenario file")
+	flag.DurationVar(&o.duration, "duration", 10*time.Minute, "simulated duration")
+	flag.DurationVar(&o.step, "step", time.Second, "simulation step")
+	flag.DurationVar(&o.pollInterval, "poll-interval", 5*time.Second, "interval of the gateway polling the controller")
+	flag.IntVar(&o.replicas, "replicas", 2, "initial replicas of the service")
+	flag.Float64Var(&o.capacity, "capacity", 40, "requests per second a single replica can serve")
+	flag.DurationVar(&o.startup, "startup", 5*time.Second, "time it takes a new replica to start")
+	flag.StringVar(&o.format, "format", "csv", "output format, csv or json")
+	flag.StringVar(&o.decisions, "decisions", "", "file to write the analyzer's decisions to as json lines")
+	flag.BoolVar(&o.traefikAPI, "traefik-api", false, "reconcile the gateway config against a stub of traefik's api")
+	flag.DurationVar(&o.stallAt, "stall-at", 0, "time from which the gateway fetches its config without applying it")
+	flag.DurationVar(&o.stallFor, "stall-for", 0, "how long the gateway stalls")
+	flag.BoolVar(&o.verbose, "v", false, "print the controller logs to stderr")
+	flag.Parse()
+
+	if !o.verbose {
+		utils.SetLogOutput(io.Discard)
+	}
+
+	scenario, err := loadScenario(o.scenarioPath)
+	if err != nil {
+		log.Fatalf("could not load scenario: %s", err)
+	}
+	cfg := internal.LoadConfigFile(o.configPath)
+
+	var w rowWriter
+	switch o.format {
+	case "csv":
+		w = newCSVWriter(os.Stdout)
+	case "json":
+		w = jsonWriter{json.NewEncoder(os.Stdout)}
+	default:
+		log.Fatalf("unknown output format %q", o.format)
+	}
+
+	var decisions *json.Encoder
+	if o.decisions != "" {
+		f, err := os.Create(o.decisions)
+		if err != nil {
+			log.Fatalf("could not create decisions file: %s", err)
+		}
+		defer f.Close()
+		decisions = json.NewEncoder(f)
+	}
+
+	err = simulate(cfg, scenario, o, w, decisions)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type row struct {
+	Time               float64 `json:"time"`
+	Replicas           int     `json:"replicas"`
+	TargetReplicas     int     `json:"target_replicas"`
+	Limit              int     `json:"limit"`
+	Bans               int     `json:"bans"`
+	OfferedRate        float64 `json:"offered_rate"`
+	AcceptedRate       float64 `json:"accepted_rate"`
+	LimitedRate        float64 `json:"limited_rate"`
+	ForbiddenRate      float64 `json:"forbidden_rate"`
+	Utilization        float64 `json:"utilization"`
+	LatencyMillis      float64 `json:"latency_ms"`
+	GoodLatencyPercent float64 `json:"good_latency_percent"`
+	Cost               float64 `json:"cost"`
+}
+
+func simulate(cfg *internal.Config, scenario *Scenario, o options, w rowWriter, decisions *json.Encoder) error {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := utils.NewVirtualClock(start)
+	s := newService(scenario, start, o.replicas, o.capacity, o.startup, cfg.Monitor.MetricsPeriod)
+
+	if o.traefikAPI {
+		stub := newTraefikStub(s)
+		defer stub.Close()
+		cfg.Execute.TraefikAPIAddress = stub.URL
+	}
+	k := knowledge.NewInMemoryBase(cfg.Knowledge.HistorySize, clock)
+	m := monitor.NewModule(cfg.Monitor, s)
+	a := analyze.NewModule(cfg.Analyze, k, clock)
+	e := execute.NewModule(cfg.Execute, k, s, nil, nil, clock)
+	p := plan.NewModule(cfg.Plan, k, e)
+	gateway := e.Handler()
+
+	// the plan module merges and executes the actions of a cycle at once,
+	// instead of waiting for the merge timeout
+	execute := func(actions []plan.AdaptationAction) {
+		err := p.Execute(actions...)
+		if err != nil {
+			log.Println("failed to execute actions:", err)
+		}
+	}
+
+	ctx := context.Background()
+	var etag string
+	err := pollGateway(gateway, s, &etag, true)
+	if err != nil {
+		return err
+	}
+	reconcile := func() {
+		err := e.Reconcile(ctx)
+		if err != nil {
+			log.Println("failed to reconcile:", err)
+		}
+	}
+	reconcile()
+	for elapsed := o.step; elapsed <= o.duration; elapsed += o.step {
+		now := clock.Advance(o.step)
+		smp := s.tick(now)
+
+		if due(elapsed, o.step, o.pollInterval) {
+			stalled := elapsed >= o.stallAt && elapsed < o.stallAt+o.stallFor
+			err := pollGateway(gateway, s, &etag, !stalled)
+			if err != nil {
+				return err
+			}
+			reconcile()
+		}
+		if due(elapsed, o.step, cfg.Monitor.ReportPeriod) {
+			r, err := m.Collect(ctx, now)
+			if err != nil {
+				log.Println(err)
+			} else {
+				execute(a.Analyze(r))
+				if decisions != nil {
+					for _, d := range k.LastDecisions(1) {
+						err := decisions.Encode(d)
+						if err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+
+		target, _ := s.Replicas(ctx)
+		bans := 0
+		k.RangeBannedIPs(func(string, knowledge.Ban) {
+			bans++
+		})
+		err := w.write(row{
+			Time:               elapsed.Seconds(),
+			Replicas:           s.running,
+			TargetReplicas:     target,
+			Limit:              s.limit,
+			Bans:               bans,
+			OfferedRate:        smp.offered,
+			AcceptedRate:       smp.accepted,
+			LimitedRate:        smp.limited,
+			ForbiddenRate:      smp.forbidden,
+			Utilization:        smp.utilization,
+			LatencyMillis:      float64(smp.latency) / float64(time.Millisecond),
+			GoodLatencyPercent: smp.goodPercent,
+			Cost:               cfg.Analyze.ReplicaCost*float64(target) + cfg.Analyze.LimitedRequestCost*smp.limited,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return w.flush()
+}
+
+func due(elapsed, step, period time.Duration) bool {
+	return period > 0 && elapsed%period < step
+}
+
+// pollGateway fetches the gateway configuration from the controller, as traefik's http provider does.
+// The configuration is only decoded if it has changed since the one with the etag, and is
+// only applied to the service if apply is set.
+func pollGateway(gateway http.Handler, s *service, etag *string, apply bool) error {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/gateway", nil)
+	if *etag != "" {
+		req.Header.Set("If-None-Match", *etag)
+	}
+	gateway.ServeHTTP(rec, req)
+	if rec.Code == http.StatusNotModified {
+		return nil
+	}
+	if rec.Code != http.StatusOK {
+		return fmt.Errorf("unexpected gateway status %d", rec.Code)
+	}
+
+	var config struct {
+		HTTP struct {
+			Middlewares struct {
+				RateLimit struct {
+					RateLimit struct {
+						Average int `json:"average"`
+					} `json:"rateLimit"`
+				} `json:"fs-rate-limit"`
+				DenyIP struct {
+					Plugin struct {
+						DenyIP struct {
+							IPDenyList []string `json:"ipDenyList"`
+						} `json:"denyip"`
+					} `json:"plugin"`
+				} `json:"fs-deny-ip"`
+			} `json:"middlewares"`
+		} `json:"http"`
+	}
+	err := json.NewDecoder(rec.Body).Decode(&config)
+	if err != nil {
+		return fmt.Errorf("could not decode gateway config: %w", err)
+	}
+	if !apply {
+		return nil
+	}
+	*etag = rec.Header().Get("ETag")
+	middlewares := config.HTTP.Middlewares
+	s.setGateway(middlewares.RateLimit.RateLimit.Average, middlewares.DenyIP.Plugin.DenyIP.IPDenyList)
+	return nil
+}
+
+type rowWriter interface {
+	write(r row) error
+	flush() error
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) write(r row) error {
+	if !c.header {
+		c.header = true
+		err := c.w.Write([]string{
+			"time", "replicas", "target_replicas", "limit", "bans", "offered_rate", "accepted_rate",
+			"limited_rate", "forbidden_rate", "utilization", "latency_ms", "good_latency_percent", "cost",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	f := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 3, 64)
+	}
+	return c.w.Write([]string{
+		f(r.Time), strconv.Itoa(r.Replicas), strconv.Itoa(r.TargetReplicas), strconv.Itoa(r.Limit),
+		strconv.Itoa(r.Bans), f(r.OfferedRate), f(r.AcceptedRate), f(r.LimitedRate), f(r.ForbiddenRate),
+		f(r.Utilization), f(r.LatencyMillis), f(r.GoodLatencyPercent), f(r.Cost),
+	})
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonWriter struct {
+	e *json.Encoder
+}
+
+func (j jsonWriter) write(r row) error {
+	return j.e.Encode(r)
+}
+
+func (jsonWriter) flush() error {
+	return nil
+}
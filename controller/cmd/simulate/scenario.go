@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"time"
+)
+
+// Scenario describes the simulated traffic, in the same format as the load test config.
+type Scenario struct {
+	Users map[string]User `yaml:"users"`
+}
+
+type User struct {
+	Start time.Duration `yaml:"start"`
+	// Stop is the time the user stops sending requests, zero means never.
+	Stop time.Duration `yaml:"stop"`
+	RPS  float64       `yaml:"rps"`
+}
+
+func (u User) active(elapsed time.Duration) bool {
+	return elapsed >= u.Start && (u.Stop == 0 || elapsed < u.Stop)
+}
+
+func loadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Scenario
+	err = yaml.Unmarshal(data, &s)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse scenario: %w", err)
+	}
+	if len(s.Users) == 0 {
+		return nil, fmt.Errorf("scenario has no users")
+	}
+	return &s, nil
+}
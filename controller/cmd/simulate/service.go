@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/execute"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	goodLatency = 1200 * time.Millisecond
+	// requests slower than the load test client's timeout are counted as timed out
+	requestTimeout = 3 * time.Second
+)
+
+// service models the protected service behind the gateway. Each replica is an M/M/1 queue
+// that serves capacity requests per second, and the gateway rate limits each source ip
+// to limit requests per second and drops banned sources.
+// It implements both execute.Orchestrator and monitor.MetricsSource.
+type service struct {
+	lock     sync.Mutex
+	scenario *Scenario
+	start    time.Time
+	now      time.Time
+	capacity float64
+	startup  time.Duration
+	window   time.Duration
+
+	running  int
+	starting []time.Time
+	limit    int
+	banned   []string
+
+	samples []sample
+}
+
+type ipSample struct {
+	total     float64
+	limited   float64
+	forbidden float64
+}
+
+type sample struct {
+	time        time.Time
+	duration    time.Duration
+	ips         map[string]ipSample
+	offered     float64
+	accepted    float64
+	limited     float64
+	forbidden   float64
+	utilization float64
+	latency     time.Duration
+	goodPercent float64
+}
+
+func newService(scenario *Scenario, start time.Time, replicas int, capacity float64, startup, window time.Duration) *service {
+	return &service{
+		scenario: scenario,
+		start:    start,
+		now:      start,
+		capacity: capacity,
+		startup:  startup,
+		window:   window,
+		running:  replicas,
+	}
+}
+
+// setGateway applies the configuration the gateway has loaded.
+func (s *service) setGateway(limit int, banned []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.limit = limit
+	s.banned = banned
+}
+
+func (s *service) isBanned(ip string) bool {
+	addr := net.ParseIP(ip)
+	for _, b := range s.banned {
+		if b == ip {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(b); err == nil && addr != nil && network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// tick simulates the traffic of the period ending at now.
+func (s *service) tick(now time.Time) sample {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	d := now.Sub(s.now)
+	s.now = now
+	for len(s.starting) > 0 && !s.starting[0].After(now) {
+		s.starting = s.starting[1:]
+		s.running++
+	}
+
+	elapsed := now.Sub(s.start)
+	smp := sample{time: now, duration: d, ips: make(map[string]ipSample)}
+	for ip, u := range s.scenario.Users {
+		if !u.active(elapsed) {
+			continue
+		}
+		is := ipSample{total: u.RPS}
+		switch {
+		case s.isBanned(ip):
+			is.forbidden = u.RPS
+		case s.limit > 0 && u.RPS > float64(s.limit):
+			is.limited = u.RPS - float64(s.limit)
+		}
+		smp.ips[ip] = is
+		smp.offered += u.RPS
+		smp.forbidden += is.forbidden
+		smp.limited += is.limited
+		smp.accepted += is.total - is.forbidden - is.limited
+	}
+
+	smp.utilization, smp.latency, smp.goodPercent = s.queue(smp.accepted)
+	s.samples = append(s.samples, smp)
+	for len(s.samples) > 0 && now.Sub(s.samples[0].time) >= s.window {
+		s.samples = s.samples[1:]
+	}
+	return smp
+}
+
+// queue returns the utilization, mean latency and the percent of requests served within
+// goodLatency, when each replica receives an equal share of the accepted rate.
+func (s *service) queue(accepted float64) (float64, time.Duration, float64) {
+	if accepted == 0 {
+		return 0, 0, 1
+	}
+	if s.running == 0 {
+		return 1, requestTimeout, 0
+	}
+	perReplica := accepted / float64(s.running)
+	spare := s.capacity - perReplica
+	if spare <= 0 {
+		return 1, requestTimeout, 0
+	}
+	latency := min(time.Duration(float64(time.Second)/spare), requestTimeout)
+	good := 1 - math.Exp(-spare*goodLatency.Seconds())
+	return perReplica / s.capacity, latency, good
+}
+
+func (s *service) Replicas(context.Context) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.running + len(s.starting), nil
+}
+
+func (s *service) Scale(_ context.Context, replicas int) error {
+	if replicas < 0 {
+		return fmt.Errorf("invalid replicas: %d", replicas)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for s.running+len(s.starting) < replicas {
+		s.starting = append(s.starting, s.now.Add(s.startup))
+	}
+	for s.running+len(s.starting) > replicas {
+		if len(s.starting) > 0 {
+			s.starting = s.starting[:len(s.starting)-1]
+		} else {
+			s.running--
+		}
+	}
+	return nil
+}
+
+func (s *service) Instances(context.Context) ([]execute.Instance, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var result []execute.Instance
+	for r := range s.running {
+		result = append(result, execute.Instance{ID: fmt.Sprintf("sim-%d", r+1), State: "running", Running: true})
+	}
+	for r := range s.starting {
+		result = append(result, execute.Instance{ID: fmt.Sprintf("sim-%d", s.running+r+1), State: "starting"})
+	}
+	return result, nil
+}
+
+// windowRate averages f over the samples in the metrics window, like a prometheus rate.
+func (s *service) windowRate(f func(sample) float64) float64 {
+	var sum float64
+	var d time.Duration
+	for _, smp := range s.samples {
+		sum += f(smp) * smp.duration.Seconds()
+		d += smp.duration
+	}
+	if d == 0 {
+		return 0
+	}
+	return sum / d.Seconds()
+}
+
+func (s *service) Requests(context.Context, time.Time) (monitor.Requests, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := monitor.Requests{
+		TotalRate:          s.windowRate(func(smp sample) float64 { return smp.accepted + smp.limited }),
+		NonLimitedRate:     s.windowRate(func(smp sample) float64 { return smp.accepted }),
+		GoodLatencyPercent: 1,
+	}
+	if result.NonLimitedRate > 0 {
+		good := s.windowRate(func(smp sample) float64 { return smp.accepted * smp.goodPercent })
+		result.GoodLatencyPercent = good / result.NonLimitedRate
+	}
+
+	var limitedRates []float64
+	for ip := range s.scenario.Users {
+		r := s.windowRate(func(smp sample) float64 { return smp.ips[ip].limited })
+		if r > 0 {
+			limitedRates = append(limitedRates, r)
+		}
+	}
+	result.LimitedRatesStdDev = stdDev(limitedRates)
+	return result, nil
+}
+
+func (s *service) CpuUtilization(context.Context, time.Time) (float64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.windowRate(func(smp sample) float64 { return smp.utilization }), nil
+}
+
+func (s *service) RunningReplicas(context.Context, time.Time) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.running, nil
+}
+
+func (s *service) IPStats(context.Context, time.Time) (map[string]monitor.IPStats, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result := make(map[string]monitor.IPStats)
+	for ip := range s.scenario.Users {
+		total := s.windowRate(func(smp sample) float64 { return smp.ips[ip].total })
+		if total == 0 {
+			continue
+		}
+		result[ip] = monitor.IPStats{
+			TotalRate:   total,
+			LimitedRate: s.windowRate(func(smp sample) float64 { return smp.ips[ip].limited }),
+			LatencySeconds: s.windowRate(func(smp sample) float64 {
+				is := smp.ips[ip]
+				return (is.total - is.limited - is.forbidden) * smp.latency.Seconds()
+			}),
+		}
+	}
+	return result, nil
+}
+
+func stdDev(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var mean float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(variance / float64(len(values)))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+)
+
+// newTraefikStub serves the middlewares the simulated gateway has loaded, like traefik's
+// /api/http/middlewares endpoint, so the controller can reconcile against it.
+func newTraefikStub(s *service) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/http/middlewares", func(w http.ResponseWriter, _ *http.Request) {
+		s.lock.Lock()
+		limit, banned := s.limit, s.banned
+		s.lock.Unlock()
+
+		middlewares := []map[string]any{
+			{
+				"name":     "fs-rate-limit@http",
+				"provider": "http",
+				"status":   "enabled",
+				"type":     "ratelimit",
+				"rateLimit": map[string]any{
+					"average": limit,
+					"burst":   limit,
+				},
+			},
+			{
+				"name":     "fs-deny-ip@http",
+				"provider": "http",
+				"status":   "enabled",
+				"type":     "plugin",
+				"plugin": map[string]any{
+					"denyip": map[string]any{
+						"ipDenyList": banned,
+					},
+				},
+			},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(middlewares)
+	})
+	return httptest.NewServer(mux)
+}
@@ -0,0 +1,399 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/plan"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Module serves an authenticated http api for operators. Changes requested through the api
+// are sent as adaptation actions, so they go through the plan module like the analyzer's.
+type Module interface {
+	Start() <-chan plan.AdaptationAction
+	Stop()
+}
+
+type Config struct {
+	Enabled bool   `config:"enabled"`
+	Address string `config:"address"`
+	Token   string `config:"token"`
+}
+
+// String masks the token, so the config can be logged.
+func (c Config) String() string {
+	token := ""
+	if c.Token != "" {
+		token = "<redacted>"
+	}
+	return fmt.Sprintf("{Enabled:%t Address:%s Token:%s}", c.Enabled, c.Address, token)
+}
+
+func (c Config) GoString() string {
+	return "admin.Config" + c.String()
+}
+
+type impl struct {
+	cfg           Config
+	knowledgeBase knowledge.Base
+	clock         utils.Clock
+	server        *http.Server
+	actions       chan plan.AdaptationAction
+	log           *log.Logger
+}
+
+func NewModule(cfg Config, k knowledge.Base, clock utils.Clock) (Module, error) {
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("admin api token is not set")
+	}
+	return &impl{
+		cfg:           cfg,
+		knowledgeBase: k,
+		clock:         clock,
+		log:           utils.GetLogger("admin"),
+	}, nil
+}
+
+func (i *impl) Start() <-chan plan.AdaptationAction {
+	i.actions = make(chan plan.AdaptationAction)
+	i.server = &http.Server{
+		Addr:    i.cfg.Address,
+		Handler: i.handler(),
+	}
+	go func() {
+		err := i.server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Error starting admin HTTP server", err)
+		}
+	}()
+
+	return i.actions
+}
+
+func (i *impl) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /state", i.handleGetState)
+	mux.HandleFunc("GET /report", i.handleGetReport)
+	mux.HandleFunc("GET /reports/aggregate", i.handleGetReportAggregate)
+	mux.HandleFunc("GET /bans", i.handleGetBans)
+	mux.HandleFunc("POST /bans", i.handleBan)
+	mux.HandleFunc("DELETE /bans/{ip}", i.handleUnban)
+	mux.HandleFunc("PUT /limit", i.handleSetLimit)
+	mux.HandleFunc("GET /adaptations", i.handleGetAdaptations)
+	mux.HandleFunc("GET /decisions", i.handleGetDecisions)
+	mux.HandleFunc("POST /pause", i.handlePause)
+	mux.HandleFunc("POST /resume", i.handleResume)
+	mux.HandleFunc("PUT /override", i.handleOverride)
+	return i.authenticate(mux)
+}
+
+func (i *impl) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := i.server.Shutdown(ctx)
+	if err != nil {
+		i.log.Println("failed to shutdown admin HTTP server:", err)
+	}
+	close(i.actions)
+}
+
+func (i *impl) authenticate(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + i.cfg.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (i *impl) bans() []Ban {
+	result := make([]Ban, 0)
+	i.knowledgeBase.RangeBannedIPs(func(ip string, b knowledge.Ban) {
+		ban := Ban{IP: ip, BannedAt: b.Since, Reason: b.Reason, Source: b.Source}
+		if !b.Permanent() {
+			ban.ExpiresAt = &b.Expiry
+		}
+		result = append(result, ban)
+	})
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].BannedAt.Before(result[b].BannedAt)
+	})
+	return result
+}
+
+func (i *impl) handleGetState(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, State{
+		Limit:                i.knowledgeBase.CurrentLimit(),
+		Replicas:             i.knowledgeBase.CurrentReplicas(),
+		PendingLimitChange:   i.knowledgeBase.HasPendingLimitChange(),
+		PendingReplicaChange: i.knowledgeBase.HasPendingReplicaChange(),
+		Mode:                 i.knowledgeBase.Mode(),
+		BannedIPs:            i.bans(),
+	})
+}
+
+func (i *impl) handleGetReport(w http.ResponseWriter, _ *http.Request) {
+	reports := i.knowledgeBase.LastReports(1)
+	if len(reports) == 0 {
+		writeError(w, http.StatusNotFound, "no report yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, reports[0])
+}
+
+// defaultAggregateWindow is the window of the report aggregate if it is not given.
+const defaultAggregateWindow = time.Minute
+
+func (i *impl) handleGetReportAggregate(w http.ResponseWriter, r *http.Request) {
+	window := defaultAggregateWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid window")
+			return
+		}
+		window = d
+	}
+	a := i.knowledgeBase.AggregateReports(window)
+	writeJSON(w, http.StatusOK, ReportAggregate{
+		Window:             window.String(),
+		From:               a.From,
+		To:                 a.To,
+		Reports:            a.Reports,
+		CpuUtilization:     newAggregate(a.CpuUtilization),
+		TotalRate:          newAggregate(a.TotalRate),
+		NonLimitedRate:     newAggregate(a.NonLimitedRate),
+		GoodLatencyPercent: newAggregate(a.GoodLatencyPercent),
+	})
+}
+
+func (i *impl) handleGetBans(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, i.bans())
+}
+
+func (i *impl) handleBan(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		IP       string `json:"ip"`
+		Duration string `json:"duration"`
+		Reason   string `json:"reason"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if _, ok := plan.NormalizeIP(body.IP); !ok {
+		writeError(w, http.StatusBadRequest, "invalid ip or cidr")
+		return
+	}
+
+	// a manual ban is permanent unless a duration is given
+	ban := knowledge.Ban{Reason: body.Reason, Source: knowledge.BanSourceManual}
+	if body.Duration != "" {
+		d, err := time.ParseDuration(body.Duration)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid duration")
+			return
+		}
+		ban.Expiry = i.clock.Now().Add(d)
+	}
+	i.log.Println("manually banning", body.IP)
+	i.submit(w, r, plan.BanIP(body.IP, ban))
+}
+
+func (i *impl) handleUnban(w http.ResponseWriter, r *http.Request) {
+	// a cidr is passed with its slash escaped
+	ip := r.PathValue("ip")
+	if _, ok := plan.NormalizeIP(ip); !ok {
+		writeError(w, http.StatusBadRequest, "invalid ip or cidr")
+		return
+	}
+	i.log.Println("manually unbanning", ip)
+	i.submit(w, r, plan.UnbanIP(ip))
+}
+
+func (i *impl) handleSetLimit(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Limit int `json:"limit"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if body.Limit <= 0 {
+		writeError(w, http.StatusBadRequest, "limit must be positive")
+		return
+	}
+	i.log.Println("manually setting limit to", body.Limit)
+	i.submit(w, r, plan.AdaptLimit(body.Limit))
+}
+
+// parseSince returns the optional since query parameter, zero if it is not given.
+func parseSince(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	v := r.URL.Query().Get("since")
+	if v == "" {
+		return time.Time{}, true
+	}
+	since, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid since, expected RFC3339 time")
+		return time.Time{}, false
+	}
+	return since, true
+}
+
+func (i *impl) handleGetAdaptations(w http.ResponseWriter, r *http.Request) {
+	since, ok := parseSince(w, r)
+	if !ok {
+		return
+	}
+
+	result := make([]Adaptation, 0)
+	for _, a := range i.knowledgeBase.LastAdaptations(-1) {
+		if !a.Time.After(since) {
+			continue
+		}
+		result = append(result, Adaptation{
+			Time:     a.Time,
+			Limit:    a.Limit,
+			Replicas: a.Replicas,
+			Banned:   a.Banned,
+			Unbanned: a.Unbanned,
+			DryRun:   a.DryRun,
+		})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleGetDecisions serves the analyzer's decisions as json lines.
+func (i *impl) handleGetDecisions(w http.ResponseWriter, r *http.Request) {
+	since, ok := parseSince(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	e := json.NewEncoder(w)
+	for _, d := range i.knowledgeBase.LastDecisions(-1) {
+		if !d.Time.After(since) {
+			continue
+		}
+		err := e.Encode(d)
+		if err != nil {
+			i.log.Println("failed to write decision:", err)
+			return
+		}
+	}
+}
+
+func (i *impl) handlePause(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Duration string `json:"duration"`
+	}
+	until, ok := i.decodeModeBody(w, r, &body, &body.Duration)
+	if !ok {
+		return
+	}
+	i.setMode(w, knowledge.Mode{Kind: knowledge.ModePaused, Until: until})
+}
+
+func (i *impl) handleResume(w http.ResponseWriter, _ *http.Request) {
+	i.setMode(w, knowledge.Mode{Kind: knowledge.ModeAuto})
+}
+
+func (i *impl) handleOverride(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Limit    int    `json:"limit"`
+		Replicas int    `json:"replicas"`
+		Duration string `json:"duration"`
+	}
+	until, ok := i.decodeModeBody(w, r, &body, &body.Duration)
+	if !ok {
+		return
+	}
+	if body.Limit < 0 || body.Replicas < 0 || body.Limit == 0 && body.Replicas == 0 {
+		writeError(w, http.StatusBadRequest, "a positive limit or replicas is required")
+		return
+	}
+
+	m := knowledge.Mode{Kind: knowledge.ModeOverride, Limit: body.Limit, Replicas: body.Replicas, Until: until}
+	i.knowledgeBase.SetMode(m)
+	i.log.Println("control loop mode set to", m)
+	var actions []plan.AdaptationAction
+	if m.Limit != 0 {
+		actions = append(actions, plan.AdaptLimit(m.Limit))
+	}
+	if m.Replicas != 0 {
+		actions = append(actions, plan.AdaptReplicas(m.Replicas))
+	}
+	i.submit(w, r, actions...)
+}
+
+// decodeModeBody decodes the optional body of a mode change, and returns when the mode ends.
+func (i *impl) decodeModeBody(w http.ResponseWriter, r *http.Request, body any, duration *string) (time.Time, bool) {
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid body")
+			return time.Time{}, false
+		}
+	}
+	if *duration == "" {
+		return time.Time{}, true
+	}
+	d, err := time.ParseDuration(*duration)
+	if err != nil || d <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid duration")
+		return time.Time{}, false
+	}
+	return i.clock.Now().Add(d), true
+}
+
+func (i *impl) setMode(w http.ResponseWriter, m knowledge.Mode) {
+	i.knowledgeBase.SetMode(m)
+	i.log.Println("control loop mode set to", m)
+	writeJSON(w, http.StatusOK, m)
+}
+
+// submit sends the actions to the plan module and responds once they are accepted.
+func (i *impl) submit(w http.ResponseWriter, r *http.Request, actions ...plan.AdaptationAction) {
+	for _, a := range actions {
+		select {
+		case i.actions <- a:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+}
+
+// writeJSON encodes v before writing the status, so a value that cannot be encoded,
+// like a NaN in a report, is answered with an error instead of a truncated body.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not encode response: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": strings.TrimSpace(msg)})
+}
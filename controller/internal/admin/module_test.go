@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"encoding/json"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/plan"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const testToken = "token"
+
+func newTestModule(t *testing.T) (*impl, knowledge.Base, *utils.VirtualClock) {
+	t.Helper()
+	clock := utils.NewVirtualClock(testStart)
+	k := knowledge.NewInMemoryBase(30, clock)
+	m, err := NewModule(Config{Token: testToken}, k, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := m.(*impl)
+	// the submitted actions are buffered, as no plan module receives them
+	i.actions = make(chan plan.AdaptationAction, 10)
+	return i, k, clock
+}
+
+func serve(i *impl, method, target string) *httptest.ResponseRecorder {
+	return serveBody(i, method, target, "")
+}
+
+func serveBody(i *impl, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	res := httptest.NewRecorder()
+	i.handler().ServeHTTP(res, req)
+	return res
+}
+
+func TestGetReportAggregate(t *testing.T) {
+	i, k, clock := newTestModule(t)
+	for idx, cpu := range []float64{0.9, 0.2, 0.4, 0.6} {
+		r := monitor.Report{Time: clock.Now(), AverageCpuUtilization: cpu}
+		r.Requests.TotalRate = float64(10 * (idx + 1))
+		r.Requests.GoodLatencyPercent = math.NaN()
+		k.RecordReport(r)
+		clock.Advance(10 * time.Second)
+	}
+
+	res := serve(i, http.MethodGet, "/reports/aggregate?window=30s")
+	if res.Code != http.StatusOK {
+		t.Fatalf("status = %d, body: %s", res.Code, res.Body)
+	}
+	var got ReportAggregate
+	err := json.Unmarshal(res.Body.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first report is older than the window
+	if got.Window != "30s" || got.Reports != 3 || !got.From.Equal(testStart.Add(10*time.Second)) || !got.To.Equal(testStart.Add(30*time.Second)) {
+		t.Errorf("aggregate = %+v", got)
+	}
+	if cpu := got.CpuUtilization; cpu.Count != 3 || math.Abs(cpu.Mean-0.4) > 1e-9 || cpu.Min != 0.2 || cpu.Max != 0.6 {
+		t.Errorf("cpu utilization = %+v", cpu)
+	}
+	if rate := got.TotalRate; rate.Mean != 30 || rate.Min != 20 || rate.Max != 40 {
+		t.Errorf("total rate = %+v", rate)
+	}
+	if got.GoodLatencyPercent != (Aggregate{}) {
+		t.Errorf("good latency percent = %+v, want the NaN values skipped", got.GoodLatencyPercent)
+	}
+
+	res = serve(i, http.MethodGet, "/reports/aggregate")
+	if res.Code != http.StatusOK {
+		t.Fatalf("status = %d, body: %s", res.Code, res.Body)
+	}
+	_ = json.Unmarshal(res.Body.Bytes(), &got)
+	if got.Window != "1m0s" || got.Reports != 4 {
+		t.Errorf("default window aggregate = %+v", got)
+	}
+
+	for _, window := range []string{"0s", "-1m", "soon"} {
+		if res := serve(i, http.MethodGet, "/reports/aggregate?window="+window); res.Code != http.StatusBadRequest {
+			t.Errorf("window %q: status = %d, want %d", window, res.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestModeEndsOnTheKnowledgeClock(t *testing.T) {
+	i, k, clock := newTestModule(t)
+	clock.Advance(time.Hour)
+
+	res := serveBody(i, http.MethodPost, "/pause", `{"duration": "10m"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("status = %d, body: %s", res.Code, res.Body)
+	}
+	if m := k.Mode(); m.Kind != knowledge.ModePaused || !m.Until.Equal(testStart.Add(70*time.Minute)) {
+		t.Errorf("mode = %+v, want paused until %s", m, testStart.Add(70*time.Minute))
+	}
+
+	res = serveBody(i, http.MethodPut, "/override", `{"limit": 20, "duration": "1h"}`)
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, body: %s", res.Code, res.Body)
+	}
+	if m := k.Mode(); m.Kind != knowledge.ModeOverride || !m.Until.Equal(testStart.Add(2*time.Hour)) {
+		t.Errorf("mode = %+v, want override until %s", m, testStart.Add(2*time.Hour))
+	}
+}
+
+func TestGetReportThatCannotBeEncoded(t *testing.T) {
+	i, k, clock := newTestModule(t)
+	r := monitor.Report{Time: clock.Now()}
+	r.Requests.TotalRate = math.Inf(1)
+	k.RecordReport(r)
+
+	res := serve(i, http.MethodGet, "/report")
+	if res.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", res.Code, http.StatusInternalServerError)
+	}
+	var body map[string]string
+	err := json.Unmarshal(res.Body.Bytes(), &body)
+	if err != nil || !strings.Contains(body["error"], "could not encode response") {
+		t.Errorf("body = %s, want a complete error", res.Body)
+	}
+}
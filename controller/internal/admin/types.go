@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+	"math"
+	"time"
+)
+
+// The types below are the bodies of the admin api responses.
+
+// Ban is an active ban, a ban without an expiry is permanent.
+type Ban struct {
+	IP        string              `json:"ip"`
+	BannedAt  time.Time           `json:"banned_at"`
+	ExpiresAt *time.Time          `json:"expires_at,omitempty"`
+	Reason    string              `json:"reason,omitempty"`
+	Source    knowledge.BanSource `json:"source"`
+}
+
+type State struct {
+	Limit                int            `json:"limit"`
+	Replicas             int            `json:"replicas"`
+	PendingLimitChange   bool           `json:"pending_limit_change"`
+	PendingReplicaChange bool           `json:"pending_replica_change"`
+	Mode                 knowledge.Mode `json:"mode"`
+	BannedIPs            []Ban          `json:"banned_ips"`
+}
+
+type Adaptation struct {
+	Time     time.Time `json:"time"`
+	Limit    int       `json:"limit,omitempty"`
+	Replicas int       `json:"replicas,omitempty"`
+	Banned   []string  `json:"banned,omitempty"`
+	Unbanned []string  `json:"unbanned,omitempty"`
+	DryRun   bool      `json:"dry_run,omitempty"`
+}
+
+// ReportAggregate summarizes the monitor reports of the last window.
+type ReportAggregate struct {
+	Window             string    `json:"window"`
+	From               time.Time `json:"from,omitempty"`
+	To                 time.Time `json:"to,omitempty"`
+	Reports            int       `json:"reports"`
+	CpuUtilization     Aggregate `json:"cpu_utilization"`
+	TotalRate          Aggregate `json:"total_rate"`
+	NonLimitedRate     Aggregate `json:"non_limited_rate"`
+	GoodLatencyPercent Aggregate `json:"good_latency_percent"`
+}
+
+// Aggregate is the count, mean, min and max of a metric's values.
+type Aggregate struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+// newAggregate returns the aggregate with zero for values that are not finite, as json cannot represent them.
+func newAggregate(a knowledge.Aggregate) Aggregate {
+	finite := func(v float64) float64 {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return 0
+		}
+		return v
+	}
+	return Aggregate{Count: a.Count, Mean: finite(a.Mean), Min: finite(a.Min), Max: finite(a.Max)}
+}
@@ -0,0 +1,116 @@
+package analyze
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// allowlist holds the addresses and prefixes that are never banned. The entries of the
+// config are fixed, while the entries of the file are reloaded when the file changes.
+type allowlist struct {
+	lock         sync.RWMutex
+	static       []*net.IPNet
+	networks     []*net.IPNet
+	path         string
+	reloadPeriod time.Duration
+	checkedAt    time.Time
+	modTime      time.Time
+	log          *log.Logger
+}
+
+func newAllowlist(entries []string, path string, reloadPeriod time.Duration, l *log.Logger) *allowlist {
+	a := &allowlist{path: path, reloadPeriod: reloadPeriod, log: l}
+	for _, e := range entries {
+		n, err := parseNetwork(e)
+		if err != nil {
+			l.Printf("ignoring allowlist entry: %s", err)
+			continue
+		}
+		a.static = append(a.static, n)
+	}
+	a.networks = a.static
+	return a
+}
+
+// parseNetwork parses a CIDR prefix, or a single address as a full length prefix.
+func parseNetwork(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 128
+		if v4 := ip.To4(); v4 != nil {
+			ip, bits = v4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip or cidr %q", s)
+	}
+	return n, nil
+}
+
+// reload reads the file again if it has changed since it was last read.
+func (a *allowlist) reload(now time.Time) {
+	if a.path == "" || now.Sub(a.checkedAt) < a.reloadPeriod {
+		return
+	}
+	a.checkedAt = now
+
+	info, err := os.Stat(a.path)
+	if err != nil {
+		a.log.Println("failed to stat allowlist file:", err)
+		return
+	}
+	if info.ModTime().Equal(a.modTime) {
+		return
+	}
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		a.log.Println("failed to read allowlist file:", err)
+		return
+	}
+
+	networks := append([]*net.IPNet{}, a.static...)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n, err := parseNetwork(line)
+		if err != nil {
+			a.log.Printf("ignoring allowlist entry: %s", err)
+			continue
+		}
+		networks = append(networks, n)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.networks = networks
+	a.modTime = info.ModTime()
+	a.log.Printf("loaded allowlist with %d entries", len(networks))
+}
+
+// allows reports whether an address or prefix overlaps with any of the entries.
+func (a *allowlist) allows(ip string) bool {
+	n, err := parseNetwork(ip)
+	if err != nil {
+		return false
+	}
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	for _, allowed := range a.networks {
+		if allowed.Contains(n.IP) || n.Contains(allowed.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package analyze
+
+import (
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+	"time"
+)
+
+// offenceCount returns the number of consecutive offences of an address, including the active one.
+// Offences are consecutive if each one starts within OffenceDecay after the previous one ends.
+func (i *impl) offenceCount(offences []knowledge.Offence) int {
+	count := 0
+	for idx := len(offences) - 1; idx >= 0; idx-- {
+		count++
+		if idx == 0 {
+			break
+		}
+		prev := offences[idx-1]
+		end := prev.UnbannedAt
+		if end.IsZero() {
+			end = prev.BannedAt
+		}
+		if i.cfg.OffenceDecay > 0 && offences[idx].BannedAt.Sub(end) > i.cfg.OffenceDecay {
+			break
+		}
+	}
+	return count
+}
+
+// banDuration returns how long a ban of an address starting at now lasts, which grows with its consecutive offences.
+func (i *impl) banDuration(ip string, now time.Time) time.Duration {
+	if len(i.cfg.BanDurations) == 0 {
+		return i.cfg.UnbanAfter
+	}
+	offences := append(i.knowledgeBase.Offences(ip), knowledge.Offence{BannedAt: now})
+	count := i.offenceCount(offences)
+	return i.cfg.BanDurations[min(count, len(i.cfg.BanDurations))-1]
+}
+
+// newBan returns an automatic ban of an address that starts now.
+func (i *impl) newBan(ip, reason string) knowledge.Ban {
+	now := i.clock.Now()
+	return knowledge.Ban{
+		Since:  now,
+		Expiry: now.Add(i.banDuration(ip, now)),
+		Reason: reason,
+		Source: knowledge.BanSourceAuto,
+	}
+}
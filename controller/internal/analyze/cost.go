@@ -0,0 +1,95 @@
+package analyze
+
+import (
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/plan"
+	"math"
+)
+
+// costPolicy scales the replicas by x and the limit by y = k * x, where k is how much the service
+// can grow to reach the target utilization and latency, and chooses the x within bounds that
+// minimizes the cost of limited requests and replicas.
+type costPolicy struct {
+	cfg Config
+}
+
+func newCostPolicy(cfg Config) (Policy, error) {
+	return &costPolicy{cfg: cfg}, nil
+}
+
+func (p *costPolicy) Decide(r monitor.Report, s Snapshot) ([]plan.AdaptationAction, Explanation) {
+	e := Explanation{Policy: PolicyCost, Values: make(map[string]float64)}
+	replicas := float64(s.Replicas)
+	limit := float64(s.Limit)
+
+	xUpper := float64(p.cfg.MaxReplicas) / replicas
+	xLower := float64(p.cfg.MinReplicas) / replicas
+	normalizeX := func(x float64) float64 {
+		if x >= xUpper {
+			x = xUpper
+			x *= math.Floor(x*replicas) / (x * replicas)
+		} else if x <= xLower {
+			x = xLower
+			x *= math.Ceil(x*replicas) / (x * replicas)
+		} else {
+			x *= math.RoundToEven(x*replicas) / (x * replicas)
+		}
+		return x
+	}
+
+	k := math.Sqrt((p.cfg.TargetUtilization / r.AverageCpuUtilization) * r.Requests.GoodLatencyPercent)
+	e.Values["k"] = k
+
+	if r.Requests.TotalRate-r.Requests.NonLimitedRate < 0.1 ||
+		math.IsNaN(r.Requests.LimitedRatesStdDev) || r.Requests.LimitedRatesStdDev > 4 {
+		e.Reason = "no consistent limiting, keeping the limit"
+		y := 1.0
+		return p.adaptResources(&e, y, normalizeX(y/k), limit, replicas)
+	}
+
+	xUpper = min(xUpper, r.Requests.TotalRate/(r.Requests.NonLimitedRate*k))
+	xLower = max(xLower, p.cfg.MinLimit/(limit*k))
+	e.Values["x_lower"] = xLower
+	e.Values["x_upper"] = xUpper
+
+	if xLower > xUpper {
+		e.Reason = "no solution, the lower bound exceeds the upper bound"
+		return nil, e
+	}
+
+	limitedCost := k * r.Requests.NonLimitedRate * p.cfg.LimitedRequestCost
+	replicaCost := replicas * p.cfg.ReplicaCost
+	slope := -limitedCost + replicaCost
+	e.Values["limited_cost"] = limitedCost
+	e.Values["replica_cost"] = replicaCost
+	e.Values["slope"] = slope
+	var x float64
+	if slope > 0 {
+		e.Reason = "replicas cost more, choosing the lower bound"
+		x = xLower
+	} else {
+		e.Reason = "limited requests cost more, choosing the upper bound"
+		x = xUpper
+	}
+	x = normalizeX(x)
+	y := k * x
+
+	return p.adaptResources(&e, y, x, limit, replicas)
+}
+
+func (p *costPolicy) adaptResources(e *Explanation, y, x, limit, oldReplicas float64) ([]plan.AdaptationAction, Explanation) {
+	e.Values["x"] = x
+	e.Values["y"] = y
+	nr := math.Round(oldReplicas * x)
+	if math.IsNaN(nr) || int(nr) == 0 {
+		e.Reason = "new replicas is NaN"
+		return nil, *e
+	}
+	if newReplicas := int(nr); newReplicas != int(oldReplicas) {
+		e.Replicas = newReplicas
+	}
+	if math.Abs(y-1) > 0.0001 {
+		e.Limit = int(math.Ceil(limit * y))
+	}
+	return e.actions(), *e
+}
@@ -0,0 +1,97 @@
+package analyze
+
+import (
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/plan"
+	"math"
+	"time"
+)
+
+// ForecastConfig configures predictive scaling. The cpu demand of the service, utilization times
+// running replicas, is forecast with Holt's linear method over the last reports, and the service
+// is scaled out ahead of time if the demand by the time new replicas start exceeds the target.
+type ForecastConfig struct {
+	Enabled bool `config:"enabled"`
+	// Reports is the number of recent reports the forecast is based on.
+	Reports int `config:"reports"`
+	// Alpha and Beta are the smoothing factors of the level and the trend.
+	Alpha float64 `config:"alpha"`
+	Beta  float64 `config:"beta"`
+	// Startup is the forecast horizon until the startup time of replicas is observed.
+	Startup time.Duration `config:"startup"`
+}
+
+// holt returns the smoothed level and trend per step of the values with Holt's linear method.
+func holt(values []float64, alpha, beta float64) (level, trend float64) {
+	level, trend = values[0], values[1]-values[0]
+	for _, v := range values[1:] {
+		prev := level
+		level = alpha*v + (1-alpha)*(level+trend)
+		trend = beta*(level-prev) + (1-beta)*trend
+	}
+	return
+}
+
+// observeStartup records how long the last scale out took, once a report shows its replicas running.
+func (i *impl) observeStartup(r monitor.Report) {
+	s := i.knowledgeBase.LastScale()
+	if !s.Up() || !s.Time.After(i.observedScale) || r.RunningReplicas < s.To {
+		return
+	}
+	i.observedScale = s.Time
+	d := r.Time.Sub(s.Time)
+	i.knowledgeBase.RecordReplicaStartup(d)
+	i.log.Printf("replicas started in %s, smoothed startup time: %s", d, i.knowledgeBase.ReplicaStartup())
+}
+
+// horizon returns how far ahead the demand is forecast.
+func (i *impl) horizon() time.Duration {
+	if d := i.knowledgeBase.ReplicaStartup(); d > 0 {
+		return d
+	}
+	return i.cfg.Forecast.Startup
+}
+
+// scaleAhead adds a scale out to the policy's decision if the forecast demand needs more replicas.
+func (i *impl) scaleAhead(s Snapshot, actions []plan.AdaptationAction, e Explanation) ([]plan.AdaptationAction, Explanation) {
+	if !i.cfg.Forecast.Enabled || s.PendingReplicaChange {
+		return actions, e
+	}
+	reports := i.knowledgeBase.LastReports(i.cfg.Forecast.Reports)
+	if len(reports) < 3 {
+		return actions, e
+	}
+	demand := make([]float64, len(reports))
+	for idx, r := range reports {
+		demand[idx] = r.AverageCpuUtilization * float64(r.RunningReplicas)
+	}
+	step := reports[len(reports)-1].Time.Sub(reports[0].Time) / time.Duration(len(reports)-1)
+	if step <= 0 {
+		return actions, e
+	}
+
+	level, trend := holt(demand, i.cfg.Forecast.Alpha, i.cfg.Forecast.Beta)
+	horizon := i.horizon()
+	forecast := level + trend*horizon.Seconds()/step.Seconds()
+	if e.Values == nil {
+		e.Values = make(map[string]float64)
+	}
+	e.Values["forecast_demand"] = forecast
+	e.Values["forecast_trend"] = trend
+	e.Values["forecast_horizon"] = horizon.Seconds()
+
+	needed := min(int(math.Ceil(forecast/i.cfg.TargetUtilization)), i.cfg.MaxReplicas)
+	// only scaling out of the current replicas is taken ahead, a scale in of the policy is left as is
+	if trend <= 0 || needed <= max(e.Replicas, s.Replicas) {
+		return actions, e
+	}
+	e.Replicas = needed
+	if e.Reason == "" {
+		e.Reason = "scaling out ahead of the forecast demand"
+	} else {
+		e.Reason = fmt.Sprintf("%s, scaling out ahead of the forecast demand", e.Reason)
+	}
+	// the last replicas action takes effect when the actions are merged
+	return append(actions, plan.AdaptReplicas(needed)), e
+}
@@ -0,0 +1,46 @@
+package analyze
+
+import (
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"testing"
+	"time"
+)
+
+func TestScaleAhead(t *testing.T) {
+	cfg := Config{
+		TargetUtilization: 1,
+		MaxReplicas:       10,
+		Forecast:          ForecastConfig{Enabled: true, Reports: 3, Alpha: 1, Beta: 1, Startup: 20 * time.Second},
+	}
+	tests := []struct {
+		name     string
+		current  int
+		decided  int
+		replicas int
+		actions  int
+	}{
+		{name: "scales out without a decision", current: 3, decided: 0, replicas: 4, actions: 1},
+		{name: "scales out over the policy's scale in", current: 3, decided: 2, replicas: 4, actions: 2},
+		{name: "leaves a scale in above the current replicas", current: 5, decided: 2, replicas: 2, actions: 1},
+		{name: "leaves the current replicas", current: 5, decided: 0, replicas: 0, actions: 0},
+		{name: "leaves a larger scale out", current: 3, decided: 5, replicas: 5, actions: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, k := newTestModule(cfg)
+			// the demand grows by 0.4 every 10s, 3.6 is forecast for 20s later
+			for idx, cpu := range []float64{0.4, 0.48, 0.56} {
+				k.RecordReport(monitor.Report{Time: testStart.Add(time.Duration(idx) * 10 * time.Second), AverageCpuUtilization: cpu, RunningReplicas: 5})
+			}
+
+			e := Explanation{Policy: "test", Replicas: tt.decided}
+			actions, e := i.scaleAhead(Snapshot{Replicas: tt.current}, e.actions(), e)
+			if e.Replicas != tt.replicas {
+				t.Errorf("replicas = %d, want %d", e.Replicas, tt.replicas)
+			}
+			if len(actions) != tt.actions {
+				t.Errorf("got %d actions, want %d", len(actions), tt.actions)
+			}
+		})
+	}
+}
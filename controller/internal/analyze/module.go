@@ -1,18 +1,20 @@
 package analyze
 
 import (
+	"fmt"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/plan"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
 	"log"
-	"math"
 	"sync"
 	"time"
 )
 
 type Module interface {
 	Start(symptoms <-chan monitor.Report) <-chan plan.AdaptationAction
+	// Analyze returns the adaptation actions for a single report.
+	Analyze(r monitor.Report) []plan.AdaptationAction
 	Stop()
 }
 
@@ -20,7 +22,16 @@ type impl struct {
 	knowledgeBase knowledge.Base
 	wg            *sync.WaitGroup
 	cfg           Config
-	log           *log.Logger
+	clock         utils.Clock
+	lastMode      knowledge.Mode
+	allowlist     *allowlist
+	reputation    *reputation
+	policy        Policy
+	shadow        Policy
+	observedScale time.Time
+	// recommendations are the replicas decided during the stabilization windows
+	recommendations []recommendation
+	log             *log.Logger
 }
 
 type Config struct {
@@ -30,15 +41,72 @@ type Config struct {
 	LimitedRequestCost float64       `config:"limited_request_cost"`
 	ReplicaCost        float64       `config:"replica_cost"`
 	MinLimit           float64       `config:"min_limit"`
-	UnbanCheckPeriod   time.Duration `config:"unban_check_period"`
 	UnbanAfter         time.Duration `config:"unban_after"`
+	// BanDurations is the schedule of ban durations for consecutive offences of an address,
+	// the last one is used for any further offences. UnbanAfter is used if it is empty.
+	BanDurations []time.Duration `config:"ban_durations"`
+	// OffenceDecay is how long after a ban ends an address can offend again to be banned for longer.
+	OffenceDecay time.Duration `config:"offence_decay"`
+
+	// IPv4PrefixLength and IPv6PrefixLength are the prefixes that addresses are aggregated into,
+	// zero disables aggregation for the address family.
+	IPv4PrefixLength int `config:"ipv4_prefix_length"`
+	IPv6PrefixLength int `config:"ipv6_prefix_length"`
+	// PrefixMinIPs is the number of addresses a prefix must have to be banned, zero disables prefix bans.
+	PrefixMinIPs int `config:"prefix_min_ips"`
+	// A prefix is banned if the limited percent of its requests exceeds PrefixAttackerThreshold,
+	// or if its rate exceeds PrefixRateFactor times the current limit.
+	PrefixAttackerThreshold float64 `config:"prefix_attacker_threshold"`
+	PrefixRateFactor        float64 `config:"prefix_rate_factor"`
+
+	// Allowlist contains the addresses and prefixes that are never banned, in addition to
+	// the ones in AllowlistPath, which has an entry per line and is reloaded when it changes.
+	Allowlist             []string      `config:"allowlist"`
+	AllowlistPath         string        `config:"allowlist_path"`
+	AllowlistReloadPeriod time.Duration `config:"allowlist_reload_period"`
+
+	// Detection selects how attacker addresses are detected, either DetectionThreshold or DetectionReputation.
+	Detection  string           `config:"detection"`
+	Reputation ReputationConfig `config:"reputation"`
+
+	// Policy selects how the limit and replicas are adapted, PolicyCost, PolicyPID or a registered policy.
+	// ShadowPolicy, if set, decides on the same reports without executing its actions, and its decisions
+	// are logged next to the ones of Policy to compare the two.
+	Policy       string    `config:"policy"`
+	ShadowPolicy string    `config:"shadow_policy"`
+	PID          PIDConfig `config:"pid"`
+
+	Forecast ForecastConfig `config:"forecast"`
+	Scaling  ScalingConfig  `config:"scaling"`
 }
 
-func NewModule(cfg Config, k knowledge.Base) Module {
+func NewModule(cfg Config, k knowledge.Base, clock utils.Clock) Module {
+	l := utils.GetLogger("analyze")
+	if cfg.Detection != DetectionThreshold && cfg.Detection != DetectionReputation {
+		l.Printf("unknown detection %q, using %s", cfg.Detection, DetectionThreshold)
+	}
 	i := &impl{
 		cfg:           cfg,
 		knowledgeBase: k,
-		log:           utils.GetLogger("analyze"),
+		clock:         clock,
+		lastMode:      knowledge.Mode{Kind: knowledge.ModeAuto},
+		allowlist:     newAllowlist(cfg.Allowlist, cfg.AllowlistPath, cfg.AllowlistReloadPeriod, l),
+		reputation:    newReputation(),
+		// scales before startup are not observed, as their replicas may have started long ago
+		observedScale: k.LastScale().Time,
+		log:           l,
+	}
+	var err error
+	i.policy, err = NewPolicy(cfg.Policy, cfg)
+	if err != nil {
+		l.Printf("%s, using %s", err, PolicyCost)
+		i.policy, _ = newCostPolicy(cfg)
+	}
+	if cfg.ShadowPolicy != "" {
+		i.shadow, err = NewPolicy(cfg.ShadowPolicy, cfg)
+		if err != nil {
+			l.Printf("%s, not running a shadow policy", err)
+		}
 	}
 	return i
 }
@@ -48,8 +116,7 @@ func (i *impl) Start(reports <-chan monitor.Report) <-chan plan.AdaptationAction
 	i.wg = &sync.WaitGroup{}
 
 	i.wg.Add(1)
-	unbans := i.startUnbanner()
-	go i.analyze(reports, unbans, actions)
+	go i.analyze(reports, actions)
 
 	return actions
 }
@@ -58,124 +125,134 @@ func (i *impl) Stop() {
 	i.wg.Wait()
 }
 
-func (i *impl) analyze(reports <-chan monitor.Report, unbans <-chan string, actions chan<- plan.AdaptationAction) {
+func (i *impl) analyze(reports <-chan monitor.Report, actions chan<- plan.AdaptationAction) {
 	defer i.wg.Done()
 	defer close(actions)
 
-	for {
-		select {
-		case r, ok := <-reports:
-			if !ok {
-				return
-			}
-			for _, a := range i.getActions(r) {
-				actions <- a
-			}
-		case ip := <-unbans:
-			i.log.Println("unbanning", ip)
-			actions <- plan.UnbanIP(ip)
-		}
+	for r := range reports {
+		i.emit(actions, i.Analyze(r)...)
 	}
 }
 
-func (i *impl) getActions(r monitor.Report) []plan.AdaptationAction {
-	var actions []plan.AdaptationAction
-	actions = append(actions, i.getBanAdaptationActions(r)...)
-	actions = append(actions, i.getResourceAdaptationActions(r)...)
-	return actions
-}
-
-func (i *impl) getBanAdaptationActions(r monitor.Report) (result []plan.AdaptationAction) {
-	for ip := range r.PotentialAttackerIPs {
-		i.log.Println("banning ip", ip)
-		result = append(result, plan.BanIP(ip))
+// emit sends the actions to the plan module, unless the control loop is paused.
+func (i *impl) emit(ch chan<- plan.AdaptationAction, actions ...plan.AdaptationAction) {
+	if len(actions) == 0 {
+		return
 	}
-	return
-}
-
-func (i *impl) getResourceAdaptationActions(r monitor.Report) []plan.AdaptationAction {
-	replicas := float64(i.knowledgeBase.CurrentReplicas())
-	limit := float64(i.knowledgeBase.CurrentLimit())
-
-	xUpper := float64(i.cfg.MaxReplicas) / replicas
-	xLower := float64(i.cfg.MinReplicas) / replicas
-	normalizeX := func(x float64) float64 {
-		if x >= xUpper {
-			x = xUpper
-			x *= math.Floor(x*replicas) / (x * replicas)
-		} else if x <= xLower {
-			x = xLower
-			x *= math.Ceil(x*replicas) / (x * replicas)
-		} else {
-			x *= math.RoundToEven(x*replicas) / (x * replicas)
-		}
-		return x
+	if i.mode().Kind == knowledge.ModePaused {
+		i.log.Printf("control loop is paused, dropping %d actions", len(actions))
+		return
 	}
-
-	k := math.Sqrt((i.cfg.TargetUtilization / r.AverageCpuUtilization) * r.Requests.GoodLatencyPercent)
-
-	if r.Requests.TotalRate-r.Requests.NonLimitedRate < 0.1 ||
-		math.IsNaN(r.Requests.LimitedRatesStdDev) || r.Requests.LimitedRatesStdDev > 4 {
-		y := 1.0
-		return i.adaptResources(y, normalizeX(y/k), limit, replicas)
+	for _, a := range actions {
+		ch <- a
 	}
+}
 
-	xUpper = min(xUpper, r.Requests.TotalRate/(r.Requests.NonLimitedRate*k))
-	xLower = max(xLower, i.cfg.MinLimit/(limit*k))
-
-	if xLower > xUpper {
-		i.log.Println("NO SOLUTION!!!")
-		return nil
+// mode returns the current mode of the control loop, and logs it when it changes.
+func (i *impl) mode() knowledge.Mode {
+	m := i.knowledgeBase.Mode()
+	if m != i.lastMode {
+		i.log.Printf("control loop mode changed from %s to %s", i.lastMode, m)
+		i.lastMode = m
 	}
+	return m
+}
+
+func (i *impl) Analyze(r monitor.Report) []plan.AdaptationAction {
+	i.knowledgeBase.RecordReport(r)
+	i.observeStartup(r)
+	s := i.snapshot()
+	m := i.mode()
+	d := knowledge.Decision{Time: r.Time, Mode: m.Kind, Inputs: decisionInputs(r, s)}
 
-	slope := -k*r.Requests.NonLimitedRate*i.cfg.LimitedRequestCost + replicas*i.cfg.ReplicaCost
-	var x float64
-	if slope > 0 {
-		x = xLower
+	actions, banned := i.getBanAdaptationActions(r)
+	d.Banned = banned
+	var e Explanation
+	var shadow *Explanation
+	if m.Kind == knowledge.ModeOverride {
+		e = i.getPinnedExplanation(m, s)
+		actions = append(actions, e.actions()...)
 	} else {
-		x = xUpper
+		var resourceActions []plan.AdaptationAction
+		resourceActions, e, shadow = i.decide(r, s)
+		actions = append(actions, resourceActions...)
 	}
-	x = normalizeX(x)
-	y := k * x
 
-	return i.adaptResources(y, x, limit, replicas)
+	d.PolicyDecision = e.record()
+	if shadow != nil {
+		sd := shadow.record()
+		d.Shadow = &sd
+	}
+	i.knowledgeBase.RecordDecision(d)
+	return actions
 }
 
-func (i *impl) adaptResources(y, x, limit, oldReplicas float64) (result []plan.AdaptationAction) {
-	nr := math.Round(oldReplicas * x)
-	if math.IsNaN(nr) || int(nr) == 0 {
-		i.log.Println("newReplicas is NaN")
-		return nil
+// getPinnedExplanation restores the values pinned by the override mode, if they have changed.
+func (i *impl) getPinnedExplanation(m knowledge.Mode, s Snapshot) Explanation {
+	e := Explanation{Policy: string(knowledge.ModeOverride)}
+	if m.Limit != 0 && m.Limit != s.Limit && !s.PendingLimitChange {
+		i.log.Printf("restoring pinned limit = %d", m.Limit)
+		e.Limit = m.Limit
 	}
-	newReplicas := int(nr)
-	if newReplicas == int(oldReplicas) {
-		i.log.Println("old replicas is equal to new replicas:", newReplicas)
-	} else {
-		result = append(result, plan.AdaptReplicas(newReplicas))
-		i.log.Printf("setting new replicas = %d", newReplicas)
+	if m.Replicas != 0 && m.Replicas != s.Replicas && !s.PendingReplicaChange {
+		i.log.Printf("restoring pinned replicas = %d", m.Replicas)
+		e.Replicas = m.Replicas
+	}
+	if e.Limit != 0 || e.Replicas != 0 {
+		e.Reason = "restoring the pinned values"
 	}
+	return e
+}
 
-	if math.Abs(y-1) > 0.0001 {
-		newLimit := int(math.Ceil(limit * y))
-		result = append(result, plan.AdaptLimit(newLimit))
-		i.log.Printf("setting new limit = %d", newLimit)
+// getBanAdaptationActions returns the ban actions for the report, and the banned addresses and prefixes.
+func (i *impl) getBanAdaptationActions(r monitor.Report) (result []plan.AdaptationAction, newBans []string) {
+	i.allowlist.reload(i.clock.Now())
+	banned := make(map[string]bool)
+	i.knowledgeBase.RangeBannedIPs(func(ip string, _ knowledge.Ban) {
+		banned[ip] = true
+	})
+
+	covered := make(map[string]bool)
+	for _, p := range i.getAttackerPrefixes(r.IPStats) {
+		if i.allowlist.allows(p.prefix.String()) {
+			i.log.Println("not banning allowlisted prefix", p.prefix)
+			continue
+		}
+		for _, ip := range p.ips {
+			covered[ip] = true
+		}
+		if banned[p.prefix.String()] {
+			continue
+		}
+		reason := fmt.Sprintf("prefix of %d ips, rate: %.2f, limited rate: %.2f", len(p.ips), p.TotalRate, p.LimitedRate)
+		i.log.Printf("banning prefix %s, %s", p.prefix, reason)
+		result = append(result, plan.BanIP(p.prefix.String(), i.newBan(p.prefix.String(), reason)))
+		newBans = append(newBans, p.prefix.String())
 	}
 
+	for ip, value := range i.getAttackerIPs(r, banned) {
+		if covered[ip] || banned[ip] {
+			continue
+		}
+		if i.allowlist.allows(ip) {
+			i.log.Println("not banning allowlisted ip", ip)
+			continue
+		}
+		reason := fmt.Sprintf("limited percent: %.2f", value)
+		if i.cfg.Detection == DetectionReputation {
+			reason = fmt.Sprintf("reputation score: %.2f", value)
+		}
+		i.log.Printf("banning ip %s, %s", ip, reason)
+		result = append(result, plan.BanIP(ip, i.newBan(ip, reason)))
+		newBans = append(newBans, ip)
+	}
 	return
 }
 
-func (i *impl) startUnbanner() <-chan string {
-	ch := make(chan string)
-	go func() {
-		for {
-			time.Sleep(i.cfg.UnbanCheckPeriod)
-			i.knowledgeBase.RangeBannedIPs(func(ip string, t time.Time) {
-				if time.Since(t) >= i.cfg.UnbanAfter {
-					ch <- ip
-				}
-			})
-		}
-	}()
-
-	return ch
+// getAttackerIPs returns the addresses detected as attackers by the configured detection.
+func (i *impl) getAttackerIPs(r monitor.Report, banned map[string]bool) map[string]float64 {
+	if i.cfg.Detection == DetectionReputation {
+		return i.updateReputation(r, banned)
+	}
+	return r.PotentialAttackerIPs
 }
@@ -0,0 +1,113 @@
+package analyze
+
+import (
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/plan"
+	"math"
+	"time"
+)
+
+const (
+	// PolicyCost chooses the limit and replicas that minimize the cost of limited requests and replicas.
+	PolicyCost = "cost"
+	// PolicyPID adjusts the limit with a PID controller, and scales the replicas proportionally to utilization.
+	PolicyPID = "pid"
+)
+
+// PIDConfig configures the PID controller of the limit. Its error is the smaller of the spare
+// utilization (TargetUtilization - utilization) and the spare good latency percent
+// (good latency percent - TargetGoodLatency), so the limit drops when either of them is exceeded.
+type PIDConfig struct {
+	Kp                float64 `config:"kp"`
+	Ki                float64 `config:"ki"`
+	Kd                float64 `config:"kd"`
+	TargetGoodLatency float64 `config:"target_good_latency"`
+	MaxLimit          float64 `config:"max_limit"`
+}
+
+type pidController struct {
+	cfg      PIDConfig
+	minLimit float64
+	integral float64
+	prevErr  float64
+	output   float64
+	current  float64
+	last     time.Time
+}
+
+// update returns the new limit for the error measured at now. The integral is reset so the output
+// starts from the current limit whenever the limit was changed by something else, like an operator.
+func (c *pidController) update(e float64, now time.Time, current float64) float64 {
+	dt := now.Sub(c.last).Seconds()
+	changed := current != c.current && current != c.output
+	c.current = current
+	if c.last.IsZero() || dt <= 0 || changed {
+		c.last, c.prevErr, c.output = now, e, current
+		c.integral = (current - c.cfg.Kp*e) / c.cfg.Ki
+		return current
+	}
+
+	derivative := (e - c.prevErr) / dt
+	integral := c.integral + e*dt
+	output := c.cfg.Kp*e + c.cfg.Ki*integral + c.cfg.Kd*derivative
+	clamped := min(max(output, c.minLimit), c.cfg.MaxLimit)
+	// anti-windup: stop integrating while the output is saturated in the direction of the error
+	if output == clamped || (output > clamped) != (e > 0) {
+		c.integral = integral
+	}
+
+	c.last, c.prevErr, c.output = now, e, math.Ceil(clamped)
+	return c.output
+}
+
+// pidPolicy adjusts the limit with a PID controller, and scales the replicas proportionally to utilization.
+type pidPolicy struct {
+	cfg Config
+	pid *pidController
+}
+
+func newPIDPolicy(cfg Config) (Policy, error) {
+	// the integral holds the current limit divided by ki, so the controller needs an integral term
+	if cfg.PID.Ki <= 0 {
+		return nil, fmt.Errorf("pid.ki must be positive, got %g", cfg.PID.Ki)
+	}
+	if cfg.PID.MaxLimit < cfg.MinLimit || cfg.PID.MaxLimit <= 0 {
+		return nil, fmt.Errorf("pid.max_limit (%g) must be positive and at least min_limit (%g)", cfg.PID.MaxLimit, cfg.MinLimit)
+	}
+	return &pidPolicy{
+		cfg: cfg,
+		pid: &pidController{cfg: cfg.PID, minLimit: cfg.MinLimit},
+	}, nil
+}
+
+func (p *pidPolicy) Decide(r monitor.Report, s Snapshot) ([]plan.AdaptationAction, Explanation) {
+	replicas := float64(s.Replicas)
+	limit := float64(s.Limit)
+
+	cpuError := p.cfg.TargetUtilization - r.AverageCpuUtilization
+	latencyError := r.Requests.GoodLatencyPercent - p.cfg.PID.TargetGoodLatency
+	e := Explanation{
+		Policy: PolicyPID,
+		Values: map[string]float64{
+			"cpu_error":     cpuError,
+			"latency_error": latencyError,
+		},
+	}
+
+	if s.PendingLimitChange {
+		e.Reason = "limit change is pending"
+	} else if newLimit := p.pid.update(min(cpuError, latencyError), r.Time, limit); newLimit != limit {
+		e.Limit = int(newLimit)
+	}
+	e.Values["integral"] = p.pid.integral
+
+	if replicas > 0 && !s.PendingReplicaChange {
+		newReplicas := math.Ceil(replicas * r.AverageCpuUtilization / p.cfg.TargetUtilization)
+		newReplicas = min(max(newReplicas, float64(p.cfg.MinReplicas)), float64(p.cfg.MaxReplicas))
+		if newReplicas != replicas {
+			e.Replicas = int(newReplicas)
+		}
+	}
+	return e.actions(), e
+}
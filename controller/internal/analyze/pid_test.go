@@ -0,0 +1,142 @@
+package analyze
+
+import (
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPIDControllerUpdate(t *testing.T) {
+	type step struct {
+		after   time.Duration
+		e       float64
+		current float64
+		// output and integral after the step
+		output   float64
+		integral float64
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "first sample seeds the integral from the current limit",
+			steps: []step{
+				{e: 0.5, current: 50, output: 50, integral: 45},
+			},
+		},
+		{
+			name: "integrates the error",
+			steps: []step{
+				{e: 0, current: 50, output: 50, integral: 50},
+				{after: time.Second, e: 0.1, current: 50, output: 52, integral: 50.1},
+				{after: 2 * time.Second, e: 0.1, current: 52, output: 52, integral: 50.3},
+			},
+		},
+		{
+			name: "same timestamp seeds the integral again",
+			steps: []step{
+				{e: 0, current: 50, output: 50, integral: 50},
+				{e: 1, current: 50, output: 50, integral: 40},
+			},
+		},
+		{
+			name: "saturated at the max limit stops integrating",
+			steps: []step{
+				{e: 0, current: 50, output: 50, integral: 50},
+				{after: time.Second, e: 2, current: 50, output: 60, integral: 50},
+				{after: time.Second, e: 2, current: 60, output: 60, integral: 50},
+			},
+		},
+		{
+			name: "saturated at the min limit stops integrating",
+			steps: []step{
+				{e: 0, current: 50, output: 50, integral: 50},
+				{after: time.Second, e: -2, current: 50, output: 40, integral: 50},
+				{after: time.Second, e: -2, current: 40, output: 40, integral: 50},
+			},
+		},
+		{
+			name: "saturated while the error unwinds it keeps integrating",
+			steps: []step{
+				{e: 0, current: 100, output: 100, integral: 100},
+				{after: time.Second, e: -0.5, current: 100, output: 60, integral: 99.5},
+			},
+		},
+		{
+			name: "external limit change seeds the integral again",
+			steps: []step{
+				{e: 0, current: 50, output: 50, integral: 50},
+				{after: time.Second, e: 0.1, current: 50, output: 52, integral: 50.1},
+				{after: time.Second, e: 0.2, current: 45, output: 45, integral: 43},
+				{after: time.Second, e: 0.2, current: 45, output: 46, integral: 43.2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &pidController{cfg: PIDConfig{Kp: 10, Ki: 1, MaxLimit: 60}, minLimit: 40}
+			now := testStart
+			for idx, s := range tt.steps {
+				now = now.Add(s.after)
+				output := c.update(s.e, now, s.current)
+				if output != s.output {
+					t.Errorf("step %d: output = %g, want %g", idx, output, s.output)
+				}
+				if math.Abs(c.integral-s.integral) > 1e-9 {
+					t.Errorf("step %d: integral = %g, want %g", idx, c.integral, s.integral)
+				}
+			}
+		})
+	}
+}
+
+func TestPIDPolicyReplicas(t *testing.T) {
+	tests := []struct {
+		name        string
+		replicas    int
+		utilization float64
+		pending     bool
+		want        int
+	}{
+		{name: "rounded up", replicas: 3, utilization: 0.8, want: 4},
+		{name: "unchanged", replicas: 3, utilization: 0.7, want: 0},
+		{name: "bounded by the min replicas", replicas: 3, utilization: 0.1, want: 2},
+		{name: "bounded by the max replicas", replicas: 3, utilization: 2, want: 6},
+		{name: "pending replica change", replicas: 3, utilization: 2, pending: true, want: 0},
+		{name: "unknown replicas", replicas: 0, utilization: 2, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newPIDPolicy(Config{
+				TargetUtilization: 0.7,
+				MinReplicas:       2,
+				MaxReplicas:       6,
+				MinLimit:          5,
+				PID:               PIDConfig{Kp: 40, Ki: 2, MaxLimit: 500, TargetGoodLatency: 0.95},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := monitor.Report{Time: testStart, AverageCpuUtilization: tt.utilization}
+			r.Requests.GoodLatencyPercent = 1
+			_, e := p.Decide(r, Snapshot{Limit: 50, Replicas: tt.replicas, PendingReplicaChange: tt.pending})
+			if e.Replicas != tt.want {
+				t.Errorf("replicas = %d, want %d", e.Replicas, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPIDPolicyValidation(t *testing.T) {
+	for _, pid := range []PIDConfig{
+		{Kp: 40, Ki: 0, MaxLimit: 500},
+		{Kp: 40, Ki: 2, MaxLimit: 4},
+		{Kp: 40, Ki: 2, MaxLimit: 0},
+	} {
+		if _, err := newPIDPolicy(Config{MinLimit: 5, PID: pid}); err == nil {
+			t.Errorf("pid config %+v was accepted", pid)
+		}
+	}
+}
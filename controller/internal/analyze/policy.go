@@ -0,0 +1,181 @@
+package analyze
+
+import (
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/plan"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Policy decides how the limit and replicas are adapted for a report.
+// A policy may keep state between reports, it is used by a single module.
+type Policy interface {
+	Decide(r monitor.Report, s Snapshot) ([]plan.AdaptationAction, Explanation)
+}
+
+// Snapshot is the state of the knowledge base that a policy decides on.
+type Snapshot struct {
+	Limit                int
+	Replicas             int
+	PendingLimitChange   bool
+	PendingReplicaChange bool
+	// Reports are the recent reports from oldest to newest, including the one being analyzed.
+	Reports []monitor.Report
+}
+
+// Explanation describes a policy's decision. Limit and Replicas are zero if they are not changed,
+// and Values holds the inputs and intermediate values that the decision was based on. It is kept
+// apart from knowledge.PolicyDecision, so policies do not depend on how decisions are stored;
+// record converts it when the decision is recorded.
+type Explanation struct {
+	Policy   string
+	Limit    int
+	Replicas int
+	Reason   string
+	Values   map[string]float64
+}
+
+func (e Explanation) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "policy %s", e.Policy)
+	if e.Limit != 0 {
+		fmt.Fprintf(&b, ", limit = %d", e.Limit)
+	}
+	if e.Replicas != 0 {
+		fmt.Fprintf(&b, ", replicas = %d", e.Replicas)
+	}
+	if e.Reason != "" {
+		fmt.Fprintf(&b, ", %s", e.Reason)
+	}
+	keys := make([]string, 0, len(e.Values))
+	for k := range e.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, ", %s: %.4g", k, e.Values[k])
+	}
+	return b.String()
+}
+
+// actions returns the actions that apply the decision.
+func (e Explanation) actions() (result []plan.AdaptationAction) {
+	if e.Replicas != 0 {
+		result = append(result, plan.AdaptReplicas(e.Replicas))
+	}
+	if e.Limit != 0 {
+		result = append(result, plan.AdaptLimit(e.Limit))
+	}
+	return
+}
+
+// PolicyFactory creates a policy from the analyze module's config, or returns an error if the config is invalid for it.
+type PolicyFactory func(cfg Config) (Policy, error)
+
+var (
+	policiesLock sync.RWMutex
+	policies     = map[string]PolicyFactory{
+		PolicyCost: newCostPolicy,
+		PolicyPID:  newPIDPolicy,
+	}
+)
+
+// RegisterPolicy makes a policy selectable by name in the config.
+func RegisterPolicy(name string, factory PolicyFactory) {
+	policiesLock.Lock()
+	defer policiesLock.Unlock()
+	policies[name] = factory
+}
+
+func NewPolicy(name string, cfg Config) (Policy, error) {
+	policiesLock.RLock()
+	defer policiesLock.RUnlock()
+	factory, ok := policies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown policy %q", name)
+	}
+	policy, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("policy %q: %w", name, err)
+	}
+	return policy, nil
+}
+
+func (i *impl) snapshot() Snapshot {
+	return Snapshot{
+		Limit:                i.knowledgeBase.CurrentLimit(),
+		Replicas:             i.knowledgeBase.CurrentReplicas(),
+		PendingLimitChange:   i.knowledgeBase.HasPendingLimitChange(),
+		PendingReplicaChange: i.knowledgeBase.HasPendingReplicaChange(),
+		Reports:              i.knowledgeBase.LastReports(snapshotReports),
+	}
+}
+
+// snapshotReports is the number of recent reports in a snapshot.
+const snapshotReports = 30
+
+// decide returns the actions of the policy, and compares its decision with the shadow policy's, if any.
+func (i *impl) decide(r monitor.Report, s Snapshot) ([]plan.AdaptationAction, Explanation, *Explanation) {
+	actions, e := i.policy.Decide(r, s)
+	actions, e = i.scaleAhead(s, actions, e)
+	actions, e = i.stabilize(s, actions, e)
+	i.log.Println("decision:", e)
+	if i.shadow == nil {
+		return actions, e, nil
+	}
+	_, se := i.shadow.Decide(r, s)
+	if se.Limit != e.Limit || se.Replicas != e.Replicas {
+		i.log.Println("shadow decision differs:", se)
+	} else {
+		i.log.Println("shadow decision agrees:", se)
+	}
+	return actions, e, &se
+}
+
+// record returns the explanation as it is recorded in the knowledge base.
+// Values that are not finite are left out, as json cannot represent them.
+func (e Explanation) record() knowledge.PolicyDecision {
+	d := knowledge.PolicyDecision{
+		Policy:   e.Policy,
+		Limit:    e.Limit,
+		Replicas: e.Replicas,
+		Reason:   e.Reason,
+	}
+	for k, v := range e.Values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		if d.Values == nil {
+			d.Values = make(map[string]float64)
+		}
+		d.Values[k] = v
+	}
+	return d
+}
+
+// finite returns zero for values that are not finite.
+func finite(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+func decisionInputs(r monitor.Report, s Snapshot) knowledge.DecisionInputs {
+	return knowledge.DecisionInputs{
+		CpuUtilization:       finite(r.AverageCpuUtilization),
+		RunningReplicas:      r.RunningReplicas,
+		TotalRate:            finite(r.Requests.TotalRate),
+		NonLimitedRate:       finite(r.Requests.NonLimitedRate),
+		LimitedRatesStdDev:   finite(r.Requests.LimitedRatesStdDev),
+		GoodLatencyPercent:   finite(r.Requests.GoodLatencyPercent),
+		Limit:                s.Limit,
+		Replicas:             s.Replicas,
+		PendingLimitChange:   s.PendingLimitChange,
+		PendingReplicaChange: s.PendingReplicaChange,
+	}
+}
@@ -0,0 +1,80 @@
+package analyze
+
+import (
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"net"
+	"sort"
+)
+
+type prefixStats struct {
+	prefix *net.IPNet
+	ips    []string
+	monitor.IPStats
+}
+
+// prefixOf returns the prefix of the configured length that contains ip,
+// or nil if aggregation is disabled for its address family.
+func (i *impl) prefixOf(ip net.IP) *net.IPNet {
+	bits, length := 128, i.cfg.IPv6PrefixLength
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits, length = v4, 32, i.cfg.IPv4PrefixLength
+	}
+	if length <= 0 || length > bits {
+		return nil
+	}
+	mask := net.CIDRMask(length, bits)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// aggregatePrefixes sums the per-ip stats of the report by prefix.
+func (i *impl) aggregatePrefixes(stats map[string]monitor.IPStats) []*prefixStats {
+	byPrefix := make(map[string]*prefixStats)
+	for ip, s := range stats {
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			continue
+		}
+		prefix := i.prefixOf(addr)
+		if prefix == nil {
+			continue
+		}
+		p, ok := byPrefix[prefix.String()]
+		if !ok {
+			p = &prefixStats{prefix: prefix}
+			byPrefix[prefix.String()] = p
+		}
+		p.ips = append(p.ips, ip)
+		p.TotalRate += s.TotalRate
+		p.LimitedRate += s.LimitedRate
+	}
+
+	result := make([]*prefixStats, 0, len(byPrefix))
+	for _, p := range byPrefix {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].prefix.String() < result[b].prefix.String()
+	})
+	return result
+}
+
+// getAttackerPrefixes returns the prefixes whose addresses collectively exceed the thresholds.
+func (i *impl) getAttackerPrefixes(stats map[string]monitor.IPStats) []*prefixStats {
+	if i.cfg.PrefixMinIPs <= 0 {
+		return nil
+	}
+	limit := float64(i.knowledgeBase.CurrentLimit())
+
+	var result []*prefixStats
+	for _, p := range i.aggregatePrefixes(stats) {
+		if len(p.ips) < i.cfg.PrefixMinIPs {
+			continue
+		}
+		limited := p.LimitedPercent() > i.cfg.PrefixAttackerThreshold
+		excessive := i.cfg.PrefixRateFactor > 0 && limit > 0 && p.TotalRate > i.cfg.PrefixRateFactor*limit
+		if limited || excessive {
+			result = append(result, p)
+		}
+	}
+	return result
+}
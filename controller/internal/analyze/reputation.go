@@ -0,0 +1,137 @@
+package analyze
+
+import (
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"math"
+	"time"
+)
+
+const (
+	// DetectionThreshold bans the addresses whose limited percent exceeds the monitor's threshold.
+	DetectionThreshold = "threshold"
+	// DetectionReputation bans the addresses whose reputation score exceeds the configured threshold.
+	DetectionReputation = "reputation"
+)
+
+// ReputationConfig configures the reputation scoring of addresses. Each feature is normalized
+// to [0, 1] and the weighted sum of the features is averaged over time with the given half-life.
+type ReputationConfig struct {
+	HalfLife  time.Duration `config:"half_life"`
+	Threshold float64       `config:"threshold"`
+
+	LimitedWeight     float64 `config:"limited_weight"`
+	RateWeight        float64 `config:"rate_weight"`
+	NotFoundWeight    float64 `config:"not_found_weight"`
+	LatencyWeight     float64 `config:"latency_weight"`
+	PathEntropyWeight float64 `config:"path_entropy_weight"`
+	OffenceWeight     float64 `config:"offence_weight"`
+	// MaxPathEntropy is the path entropy in bits at which the path entropy feature saturates.
+	MaxPathEntropy float64 `config:"max_path_entropy"`
+}
+
+type reputation struct {
+	last   time.Time
+	scores map[string]float64
+}
+
+func newReputation() *reputation {
+	return &reputation{
+		scores: make(map[string]float64),
+	}
+}
+
+// features are the normalized signals of an address in a report.
+type features struct {
+	Limited     float64
+	Rate        float64
+	NotFound    float64
+	Latency     float64
+	PathEntropy float64
+	Offences    float64
+}
+
+func (f features) score(c ReputationConfig) float64 {
+	return c.LimitedWeight*f.Limited +
+		c.RateWeight*f.Rate +
+		c.NotFoundWeight*f.NotFound +
+		c.LatencyWeight*f.Latency +
+		c.PathEntropyWeight*f.PathEntropy +
+		c.OffenceWeight*f.Offences
+}
+
+func (i *impl) features(ip string, s monitor.IPStats, totalLatency float64, now time.Time) features {
+	f := features{
+		Limited:  s.LimitedPercent(),
+		NotFound: s.NotFoundPercent(),
+		Offences: 1 - math.Exp2(-float64(i.recentOffences(ip, now))),
+	}
+	if limit := float64(i.knowledgeBase.CurrentLimit()); limit > 0 {
+		f.Rate = min(s.TotalRate/limit, 1)
+	}
+	if totalLatency > 0 {
+		f.Latency = s.LatencySeconds / totalLatency
+	}
+	if i.cfg.Reputation.MaxPathEntropy > 0 {
+		f.PathEntropy = min(s.PathEntropy/i.cfg.Reputation.MaxPathEntropy, 1)
+	}
+	return f
+}
+
+// updateReputation scores the addresses of the report and returns the ones whose score exceeds the threshold.
+// The first report only sets the time the scores decay from.
+func (i *impl) updateReputation(r monitor.Report, banned map[string]bool) map[string]float64 {
+	rep := i.reputation
+	first := rep.last.IsZero()
+	dt := r.Time.Sub(rep.last)
+	rep.last = r.Time
+	if first || dt <= 0 {
+		return nil
+	}
+	decay := 0.0
+	if i.cfg.Reputation.HalfLife > 0 {
+		decay = math.Exp2(-dt.Seconds() / i.cfg.Reputation.HalfLife.Seconds())
+	}
+
+	var totalLatency float64
+	for _, s := range r.IPStats {
+		totalLatency += s.LatencySeconds
+	}
+	for ip, score := range rep.scores {
+		if _, ok := r.IPStats[ip]; !ok {
+			rep.scores[ip] = score * decay
+			if rep.scores[ip] < 0.001 {
+				delete(rep.scores, ip)
+			}
+		}
+	}
+
+	result := make(map[string]float64)
+	for ip, s := range r.IPStats {
+		if banned[ip] {
+			delete(rep.scores, ip)
+			continue
+		}
+		f := i.features(ip, s, totalLatency, r.Time)
+		score := decay*rep.scores[ip] + (1-decay)*f.score(i.cfg.Reputation)
+		rep.scores[ip] = score
+		if score > i.cfg.Reputation.Threshold {
+			i.log.Printf("ip %s has score %f, features: %+v", ip, score, f)
+			result[ip] = score
+		}
+	}
+	return result
+}
+
+// recentOffences returns the number of consecutive offences of an address,
+// or zero if the last one ended more than OffenceDecay ago.
+func (i *impl) recentOffences(ip string, now time.Time) int {
+	offences := i.knowledgeBase.Offences(ip)
+	if len(offences) == 0 {
+		return 0
+	}
+	last := offences[len(offences)-1]
+	if !last.UnbannedAt.IsZero() && i.cfg.OffenceDecay > 0 && now.Sub(last.UnbannedAt) > i.cfg.OffenceDecay {
+		return 0
+	}
+	return i.offenceCount(offences)
+}
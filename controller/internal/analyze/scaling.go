@@ -0,0 +1,104 @@
+package analyze
+
+import (
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/plan"
+	"time"
+)
+
+// ScalingConfig limits how often and how much the replicas change, like the behavior field of
+// Kubernetes' HorizontalPodAutoscaler. Zero values disable the corresponding limit.
+type ScalingConfig struct {
+	// ScaleUpCooldown is the minimum time between two scale ups, and ScaleDownCooldown
+	// is the minimum time between any scale and a following scale down.
+	ScaleUpCooldown   time.Duration `config:"scale_up_cooldown"`
+	ScaleDownCooldown time.Duration `config:"scale_down_cooldown"`
+	// The service is scaled up to the lowest replicas recommended during ScaleUpStabilization,
+	// and down to the highest replicas recommended during ScaleDownStabilization.
+	ScaleUpStabilization   time.Duration `config:"scale_up_stabilization"`
+	ScaleDownStabilization time.Duration `config:"scale_down_stabilization"`
+	// MaxScaleUpStep and MaxScaleDownStep are the most replicas added or removed by a single scale.
+	MaxScaleUpStep   int `config:"max_scale_up_step"`
+	MaxScaleDownStep int `config:"max_scale_down_step"`
+}
+
+type recommendation struct {
+	time     time.Time
+	replicas int
+}
+
+// recommend records the replicas recommended now, and returns the lowest and highest ones recommended
+// during the scale up and scale down stabilization windows.
+func (i *impl) recommend(now time.Time, replicas int) (lowest, highest int) {
+	c := i.cfg.Scaling
+	i.recommendations = append(i.recommendations, recommendation{time: now, replicas: replicas})
+	window := max(c.ScaleUpStabilization, c.ScaleDownStabilization)
+	for len(i.recommendations) > 1 && now.Sub(i.recommendations[0].time) > window {
+		i.recommendations = i.recommendations[1:]
+	}
+
+	lowest, highest = replicas, replicas
+	for _, r := range i.recommendations {
+		age := now.Sub(r.time)
+		if age <= c.ScaleUpStabilization {
+			lowest = min(lowest, r.replicas)
+		}
+		if age <= c.ScaleDownStabilization {
+			highest = max(highest, r.replicas)
+		}
+	}
+	return
+}
+
+// stabilize limits the replica change of a decision by the stabilization windows, step sizes and cooldowns.
+func (i *impl) stabilize(s Snapshot, actions []plan.AdaptationAction, e Explanation) ([]plan.AdaptationAction, Explanation) {
+	current := s.Replicas
+	if current == 0 {
+		return actions, e
+	}
+	c := i.cfg.Scaling
+	now := i.clock.Now()
+	desired := e.Replicas
+	if desired == 0 {
+		desired = current
+	}
+
+	lowest, highest := i.recommend(now, desired)
+	replicas, reason := current, ""
+	if current < lowest {
+		replicas = lowest
+		if c.MaxScaleUpStep > 0 && replicas-current > c.MaxScaleUpStep {
+			replicas, reason = current+c.MaxScaleUpStep, "limited by the max scale up step"
+		}
+		if last := i.knowledgeBase.LastScaleUp(); c.ScaleUpCooldown > 0 && now.Sub(last.Time) < c.ScaleUpCooldown {
+			replicas, reason = current, "scale up is cooling down"
+		}
+	} else if current > highest {
+		replicas = highest
+		if c.MaxScaleDownStep > 0 && current-replicas > c.MaxScaleDownStep {
+			replicas, reason = current-c.MaxScaleDownStep, "limited by the max scale down step"
+		}
+		if last := i.knowledgeBase.LastScale(); c.ScaleDownCooldown > 0 && now.Sub(last.Time) < c.ScaleDownCooldown {
+			replicas, reason = current, "scale down is cooling down"
+		}
+	}
+	if replicas == desired {
+		return actions, e
+	}
+
+	if reason == "" {
+		reason = "stabilized"
+	}
+	reason = fmt.Sprintf("%s to %d replicas", reason, replicas)
+	if e.Reason != "" {
+		reason = fmt.Sprintf("%s, %s", e.Reason, reason)
+	}
+	e.Reason = reason
+	// the actions are built again from the explanation, so holding the replicas leaves out the
+	// policy's replicas action, instead of cancelling the replica changes of other sources
+	e.Replicas = 0
+	if replicas != current {
+		e.Replicas = replicas
+	}
+	return e.actions(), e
+}
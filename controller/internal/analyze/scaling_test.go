@@ -0,0 +1,199 @@
+package analyze
+
+import (
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestModule returns a module that decides at testStart, without a policy.
+func newTestModule(cfg Config) (*impl, knowledge.Base) {
+	clock := utils.NewVirtualClock(testStart)
+	k := knowledge.NewInMemoryBase(30, clock)
+	return &impl{
+		cfg:           cfg,
+		clock:         clock,
+		knowledgeBase: k,
+		log:           log.New(&strings.Builder{}, "", 0),
+	}, k
+}
+
+// past is a recommendation or a scale that was made ago before testStart.
+type past struct {
+	ago      time.Duration
+	replicas int
+}
+
+func TestRecommend(t *testing.T) {
+	cfg := Config{Scaling: ScalingConfig{
+		ScaleUpStabilization:   30 * time.Second,
+		ScaleDownStabilization: time.Minute,
+	}}
+	tests := []struct {
+		name            string
+		history         []past
+		replicas        int
+		lowest, highest int
+		kept            int
+	}{
+		{name: "no history", replicas: 3, lowest: 3, highest: 3, kept: 1},
+		{
+			name:     "lowest in the scale up window",
+			history:  []past{{ago: 20 * time.Second, replicas: 2}, {ago: 45 * time.Second, replicas: 1}},
+			replicas: 4, lowest: 2, highest: 4, kept: 3,
+		},
+		{
+			name:     "highest in the scale down window",
+			history:  []past{{ago: 50 * time.Second, replicas: 5}, {ago: 10 * time.Second, replicas: 3}},
+			replicas: 2, lowest: 2, highest: 5, kept: 3,
+		},
+		{
+			name:     "older recommendations are dropped",
+			history:  []past{{ago: 2 * time.Minute, replicas: 8}, {ago: 90 * time.Second, replicas: 1}},
+			replicas: 3, lowest: 3, highest: 3, kept: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, _ := newTestModule(cfg)
+			for _, h := range tt.history {
+				i.recommendations = append(i.recommendations, recommendation{time: testStart.Add(-h.ago), replicas: h.replicas})
+			}
+			lowest, highest := i.recommend(testStart, tt.replicas)
+			if lowest != tt.lowest || highest != tt.highest {
+				t.Errorf("recommend() = %d, %d, want %d, %d", lowest, highest, tt.lowest, tt.highest)
+			}
+			if len(i.recommendations) != tt.kept {
+				t.Errorf("kept %d recommendations, want %d", len(i.recommendations), tt.kept)
+			}
+		})
+	}
+}
+
+func TestStabilize(t *testing.T) {
+	tests := []struct {
+		name     string
+		scaling  ScalingConfig
+		history  []past
+		scales   []knowledge.Scale
+		current  int
+		desired  int
+		replicas int
+		reason   string
+	}{
+		{
+			name:    "scale up passes through without options",
+			current: 2, desired: 4, replicas: 4,
+		},
+		{
+			name: "scale up passes through the scale down options",
+			scaling: ScalingConfig{
+				ScaleDownCooldown:      2 * time.Minute,
+				ScaleDownStabilization: time.Minute,
+				MaxScaleDownStep:       1,
+			},
+			history: []past{{ago: 30 * time.Second, replicas: 6}},
+			scales:  []knowledge.Scale{{Time: testStart.Add(-10 * time.Second), From: 3, To: 2}},
+			current: 2, desired: 4, replicas: 4,
+		},
+		{
+			name:    "scale up cooldown holds the replicas",
+			scaling: ScalingConfig{ScaleUpCooldown: 30 * time.Second},
+			scales:  []knowledge.Scale{{Time: testStart.Add(-10 * time.Second), From: 1, To: 2}},
+			current: 2, desired: 4, replicas: 0,
+			reason: "scale up is cooling down to 2 replicas",
+		},
+		{
+			name:    "scale up after the cooldown",
+			scaling: ScalingConfig{ScaleUpCooldown: 30 * time.Second},
+			scales:  []knowledge.Scale{{Time: testStart.Add(-40 * time.Second), From: 1, To: 2}},
+			current: 2, desired: 4, replicas: 4,
+		},
+		{
+			name:    "scale down cooldown follows a scale up",
+			scaling: ScalingConfig{ScaleDownCooldown: 2 * time.Minute},
+			scales:  []knowledge.Scale{{Time: testStart.Add(-time.Minute), From: 2, To: 4}},
+			current: 4, desired: 2, replicas: 0,
+			reason: "scale down is cooling down to 4 replicas",
+		},
+		{
+			name:    "scale down to the highest of the stabilization window",
+			scaling: ScalingConfig{ScaleDownStabilization: time.Minute},
+			history: []past{{ago: 90 * time.Second, replicas: 5}, {ago: 30 * time.Second, replicas: 4}},
+			current: 5, desired: 2, replicas: 4,
+			reason: "stabilized to 4 replicas",
+		},
+		{
+			name:    "stabilization window holds the replicas",
+			scaling: ScalingConfig{ScaleDownStabilization: time.Minute},
+			history: []past{{ago: 30 * time.Second, replicas: 5}},
+			current: 5, desired: 2, replicas: 0,
+			reason: "stabilized to 5 replicas",
+		},
+		{
+			name:    "scale up to the lowest of the stabilization window",
+			scaling: ScalingConfig{ScaleUpStabilization: time.Minute},
+			history: []past{{ago: 20 * time.Second, replicas: 3}},
+			current: 2, desired: 5, replicas: 3,
+			reason: "stabilized to 3 replicas",
+		},
+		{
+			name:    "scale down step",
+			scaling: ScalingConfig{MaxScaleDownStep: 1},
+			current: 5, desired: 2, replicas: 4,
+			reason: "limited by the max scale down step to 4 replicas",
+		},
+		{
+			name:    "scale up step",
+			scaling: ScalingConfig{MaxScaleUpStep: 2},
+			current: 2, desired: 7, replicas: 4,
+			reason: "limited by the max scale up step to 4 replicas",
+		},
+		{
+			name:    "step within the limit",
+			scaling: ScalingConfig{MaxScaleUpStep: 2, MaxScaleDownStep: 1},
+			current: 2, desired: 3, replicas: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, k := newTestModule(Config{Scaling: tt.scaling})
+			for _, h := range tt.history {
+				i.recommendations = append(i.recommendations, recommendation{time: testStart.Add(-h.ago), replicas: h.replicas})
+			}
+			for _, s := range tt.scales {
+				k.RecordScale(s)
+			}
+
+			e := Explanation{Policy: "test", Replicas: tt.desired}
+			actions, e := i.stabilize(Snapshot{Replicas: tt.current}, e.actions(), e)
+			if e.Replicas != tt.replicas {
+				t.Errorf("replicas = %d, want %d", e.Replicas, tt.replicas)
+			}
+			if e.Reason != tt.reason {
+				t.Errorf("reason = %q, want %q", e.Reason, tt.reason)
+			}
+			// holding the replicas leaves out the replicas action
+			if wantActions := min(tt.replicas, 1); len(actions) != wantActions {
+				t.Errorf("got %d actions, want %d", len(actions), wantActions)
+			}
+		})
+	}
+}
+
+func TestStabilizeKeepsPolicyReason(t *testing.T) {
+	i, _ := newTestModule(Config{Scaling: ScalingConfig{MaxScaleDownStep: 1}})
+	e := Explanation{Policy: "test", Replicas: 1, Limit: 30, Reason: "low utilization"}
+	actions, e := i.stabilize(Snapshot{Replicas: 3}, e.actions(), e)
+	if e.Reason != "low utilization, limited by the max scale down step to 2 replicas" {
+		t.Errorf("reason = %q", e.Reason)
+	}
+	if e.Limit != 30 || len(actions) != 2 {
+		t.Errorf("limit = %d with %d actions, want 30 with 2", e.Limit, len(actions))
+	}
+}
@@ -1,8 +1,10 @@
 package internal
 
 import (
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/admin"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/analyze"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/execute"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/plan"
 	"github.com/knadh/koanf/providers/env"
@@ -24,14 +26,21 @@ const (
 )
 
 type Config struct {
-	Monitor monitor.Config `config:"monitor"`
-	Analyze analyze.Config `config:"analyze"`
-	Plan    plan.Config    `config:"plan"`
-	Execute execute.Config `config:"execute"`
+	Knowledge knowledge.Config `config:"knowledge"`
+	Monitor   monitor.Config   `config:"monitor"`
+	Analyze   analyze.Config   `config:"analyze"`
+	Plan      plan.Config      `config:"plan"`
+	Execute   execute.Config   `config:"execute"`
+	Admin     admin.Config     `config:"admin"`
 }
 
 func Default() *Config {
 	return &Config{
+		Knowledge: knowledge.Config{
+			Store:       knowledge.StoreMemory,
+			Path:        "/var/lib/aad/knowledge.log",
+			HistorySize: 360,
+		},
 		Monitor: monitor.Config{
 			MetricsAddress:           "http://localhost:9090",
 			MetricsPeriod:            20 * time.Second,
@@ -46,20 +55,105 @@ func Default() *Config {
 			LimitedRequestCost: 50,
 			ReplicaCost:        200,
 			MinLimit:           5,
-			UnbanCheckPeriod:   10 * time.Second,
 			UnbanAfter:         time.Minute,
+			BanDurations:       []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, 24 * time.Hour},
+			OffenceDecay:       24 * time.Hour,
+
+			IPv4PrefixLength:        24,
+			IPv6PrefixLength:        64,
+			PrefixMinIPs:            0,
+			PrefixAttackerThreshold: 0.25,
+			PrefixRateFactor:        4,
+
+			AllowlistReloadPeriod: 30 * time.Second,
+			Detection:             analyze.DetectionThreshold,
+			Reputation: analyze.ReputationConfig{
+				HalfLife:          20 * time.Second,
+				Threshold:         0.4,
+				LimitedWeight:     0.4,
+				RateWeight:        0.2,
+				NotFoundWeight:    0.1,
+				LatencyWeight:     0.1,
+				PathEntropyWeight: 0.1,
+				OffenceWeight:     0.1,
+				MaxPathEntropy:    6,
+			},
+			Policy: analyze.PolicyCost,
+			PID: analyze.PIDConfig{
+				Kp:                40,
+				Ki:                2,
+				Kd:                0,
+				TargetGoodLatency: 0.95,
+				MaxLimit:          500,
+			},
+			Forecast: analyze.ForecastConfig{
+				Enabled: false,
+				Reports: 12,
+				Alpha:   0.5,
+				Beta:    0.3,
+				Startup: 30 * time.Second,
+			},
+			// scaling behavior is opt-in, the replicas follow the policy unless it is configured
+			Scaling: analyze.ScalingConfig{
+				ScaleUpCooldown:        0,
+				ScaleDownCooldown:      0,
+				ScaleUpStabilization:   0,
+				ScaleDownStabilization: 0,
+				MaxScaleUpStep:         0,
+				MaxScaleDownStep:       0,
+			},
 		},
 		Plan: plan.Config{
 			MergeTimeout:     3 * time.Second,
 			ExecutionTimeout: 10 * time.Second,
 		},
 		Execute: execute.Config{
-			InitialLimit: 50,
+			InitialLimit:        50,
+			Orchestrator:        execute.OrchestratorSwarm,
+			ServiceName:         "file-server",
+			InitialReplicas:     1,
+			Gateway:             execute.GatewayTraefik,
+			ReconcilePeriod:     5 * time.Second,
+			MaxReconcileRetries: 3,
+			Kubernetes: execute.KubernetesConfig{
+				Address:   "https://kubernetes.default.svc",
+				Namespace: "default",
+				Kind:      execute.KindDeployment,
+				TokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+				CAFile:    "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+			},
+			Nginx: execute.NginxConfig{
+				ConfigPath:    "/etc/nginx/conf.d/anti-dos.conf",
+				TestCommand:   []string{"nginx", "-t"},
+				ReloadCommand: []string{"nginx", "-s", "reload"},
+				ZoneSize:      "10m",
+			},
+			HAProxy: execute.HAProxyConfig{
+				Socket:   "/var/run/haproxy/admin.sock",
+				BanMap:   "/etc/haproxy/banned.map",
+				LimitMap: "/etc/haproxy/limit.map",
+				LimitKey: "limit",
+			},
+			Nftables: execute.NftablesConfig{
+				Family:  "inet",
+				Table:   "anti_dos",
+				IPv4Set: "banned4",
+				IPv6Set: "banned6",
+				Command: []string{"nft"},
+			},
+		},
+		Admin: admin.Config{
+			Address: ":6042",
 		},
 	}
 }
 
 func LoadConfig() *Config {
+	return LoadConfigFile("/etc/config.yaml")
+}
+
+// LoadConfigFile loads the defaults, then the given yaml file if it is not empty, then the environment.
+func LoadConfigFile(path string) *Config {
 	k := koanf.New(delimiter)
 	{
 		err := k.Load(structs.Provider(Default(), tag), nil)
@@ -68,8 +162,8 @@ func LoadConfig() *Config {
 		}
 	}
 
-	{
-		err := k.Load(file.Provider("/etc/config.yaml"), yaml.Parser())
+	if path != "" {
+		err := k.Load(file.Provider(path), yaml.Parser())
 		if err != nil {
 			log.Printf("could not load yaml config: %s\n", err)
 		}
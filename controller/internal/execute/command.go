@@ -0,0 +1,43 @@
+package execute
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Commander runs external commands. Backends run their commands through it,
+// so they can be exercised without the commands or the privileges they need.
+type Commander interface {
+	// Run runs the command with the input, and returns its output. A failed
+	// command's error includes what it wrote to stderr. An empty command does nothing.
+	Run(ctx context.Context, input []byte, command ...string) ([]byte, error)
+}
+
+type execCommander struct{}
+
+// ExecCommander runs the commands as processes.
+var ExecCommander Commander = execCommander{}
+
+func (execCommander) Run(ctx context.Context, input []byte, command ...string) ([]byte, error) {
+	if len(command) == 0 {
+		return nil, nil
+	}
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err == nil {
+		return out, nil
+	}
+	err = fmt.Errorf("%s: %w", strings.Join(command, " "), err)
+	if msg := strings.TrimSpace(stderr.String() + string(out)); msg != "" {
+		err = fmt.Errorf("%w: %s", err, msg)
+	}
+	return out, err
+}
@@ -0,0 +1,92 @@
+package execute
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Decision is a change that the execute module was asked to make, along with the state
+// at that moment, so the decisions of a dry run can be compared with what actually happened.
+type Decision struct {
+	Time   time.Time `json:"time"`
+	DryRun bool      `json:"dry_run"`
+	Action string    `json:"action"`
+	IP     string    `json:"ip,omitempty"`
+	// Value is the requested replicas or limit, and Current is the value in effect.
+	Value   int `json:"value,omitempty"`
+	Current int `json:"current,omitempty"`
+	// Reason and Expiry describe a ban, a ban without an expiry is permanent.
+	Reason string     `json:"reason,omitempty"`
+	Expiry *time.Time `json:"expiry,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+const (
+	actionScale = "scale"
+	actionLimit = "limit"
+	actionBan   = "ban"
+	actionUnban = "unban"
+)
+
+func (d Decision) String() string {
+	switch d.Action {
+	case actionBan, actionUnban:
+		return fmt.Sprintf("%s %s", d.Action, d.IP)
+	default:
+		return fmt.Sprintf("%s to %d (current %d)", d.Action, d.Value, d.Current)
+	}
+}
+
+// decisionLog appends decisions to a file as json lines.
+type decisionLog struct {
+	lock  sync.Mutex
+	file  *os.File
+	clock utils.Clock
+	log   *log.Logger
+}
+
+func newDecisionLog(path string, clock utils.Clock, l *log.Logger) (*decisionLog, error) {
+	d := &decisionLog{clock: clock, log: l}
+	if path == "" {
+		return d, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	d.file = f
+	return d, nil
+}
+
+func (d *decisionLog) record(decision Decision) {
+	decision.Time = d.clock.Now()
+	if decision.DryRun {
+		d.log.Println("dry run, would", decision)
+	}
+	if d.file == nil {
+		return
+	}
+
+	data, err := json.Marshal(decision)
+	if err != nil {
+		d.log.Println("could not encode decision:", err)
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, err = d.file.Write(append(data, '\n'))
+	if err != nil {
+		d.log.Println("could not write decision:", err)
+	}
+}
+
+func (d *decisionLog) close() {
+	if d.file != nil {
+		_ = d.file.Close()
+	}
+}
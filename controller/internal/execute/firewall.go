@@ -0,0 +1,62 @@
+package execute
+
+import (
+	"context"
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+)
+
+// Firewall drops the traffic of banned addresses on the host, before it reaches the gateway.
+// It complements the gateway, which still denies the bans and enforces the limit.
+type Firewall interface {
+	// Apply makes the firewall drop the banned addresses until their bans expire. It is
+	// called periodically, so it should do nothing if the firewall already drops them.
+	Apply(ctx context.Context, bans map[string]knowledge.Ban) error
+}
+
+const (
+	FirewallNftables = "nftables"
+)
+
+// NewFirewall returns the firewall of the config, or nil if there is none.
+func NewFirewall(cfg Config) (Firewall, error) {
+	switch cfg.Firewall {
+	case "":
+		return nil, nil
+	case FirewallNftables:
+		return NewNftablesFirewall(cfg.Nftables), nil
+	default:
+		return nil, fmt.Errorf("unknown firewall %q", cfg.Firewall)
+	}
+}
+
+// desiredBans returns the bans in the knowledge base along with the pending ones.
+func (i *impl) desiredBans() map[string]knowledge.Ban {
+	bans := make(map[string]knowledge.Ban)
+	i.knowledgeBase.RangeBannedIPs(func(ip string, b knowledge.Ban) {
+		bans[ip] = b
+	})
+	i.banOrUnban.Range(func(ip, p any) bool {
+		if p.(pendingBan).ban {
+			bans[ip.(string)] = p.(pendingBan).Ban
+		} else {
+			delete(bans, ip.(string))
+		}
+		return true
+	})
+	return bans
+}
+
+// reconcileFirewall applies the bans to the firewall, if there is one.
+func (i *impl) reconcileFirewall(ctx context.Context) error {
+	if i.firewall == nil || i.cfg.DryRun {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, i.cfg.ReconcilePeriod)
+	defer cancel()
+	err := i.firewall.Apply(ctx, i.desiredBans())
+	if err != nil {
+		return fmt.Errorf("firewall: %w", err)
+	}
+	return nil
+}
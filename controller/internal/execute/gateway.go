@@ -0,0 +1,148 @@
+package execute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Gateway enforces the rate limit and the banned addresses in front of the service.
+// Unlike Traefik, which pulls its configuration from the module's handler, changes are
+// pushed to a Gateway.
+type Gateway interface {
+	// Apply makes the gateway enforce the limit per source and deny the sorted banned addresses.
+	// It is called periodically, so it should do nothing if the gateway already enforces them.
+	Apply(ctx context.Context, limit int, banned []string) error
+}
+
+const (
+	GatewayTraefik = "traefik"
+	GatewayNginx   = "nginx"
+	GatewayHAProxy = "haproxy"
+)
+
+// NewGateway returns the gateway that changes are pushed to, or nil for Traefik.
+func NewGateway(cfg Config) (Gateway, error) {
+	exempt := len(cfg.RateLimitExempt) > 0
+	switch cfg.Gateway {
+	case GatewayTraefik, "":
+		if exempt && cfg.RateLimitExemptService == "" {
+			return nil, fmt.Errorf("rate limit exempt sources need the service of the traefik exempt router")
+		}
+		return nil, nil
+	case GatewayNginx:
+		return NewNginxGateway(cfg.Nginx, cfg.RateLimitExempt), nil
+	case GatewayHAProxy:
+		if exempt && cfg.HAProxy.ExemptMap == "" {
+			return nil, fmt.Errorf("rate limit exempt sources need the haproxy exempt map")
+		}
+		return NewHAProxyGateway(cfg.HAProxy, cfg.RateLimitExempt), nil
+	default:
+		return nil, fmt.Errorf("unknown gateway %q", cfg.Gateway)
+	}
+}
+
+// desiredGateway returns the limit and banned addresses that the gateway should apply,
+// with the pending bans and unbans that are included in them.
+func (i *impl) desiredGateway() (int, []string, map[string]pendingBan) {
+	limit := int(i.limit.Load())
+
+	banned := make(map[string]bool)
+	i.knowledgeBase.RangeBannedIPs(func(ip string, _ knowledge.Ban) {
+		banned[ip] = true
+	})
+	pending := make(map[string]pendingBan)
+	i.banOrUnban.Range(func(ip, p any) bool {
+		pending[ip.(string)] = p.(pendingBan)
+		banned[ip.(string)] = p.(pendingBan).ban
+		return true
+	})
+	bannedIPs := make([]string, 0)
+	for ip, ban := range banned {
+		if ban {
+			bannedIPs = append(bannedIPs, ip)
+		}
+	}
+	sort.Strings(bannedIPs)
+	return limit, bannedIPs, pending
+}
+
+// applied commits the limit, and the pending bans that the gateway applied, to the knowledge base.
+func (i *impl) applied(limit int, banned []string, pending map[string]pendingBan) {
+	i.knowledgeBase.SetLimit(limit)
+	for ip, p := range pending {
+		if p.ban {
+			i.knowledgeBase.BanIP(ip, p.Ban)
+		} else {
+			i.knowledgeBase.UnbanIP(ip)
+		}
+		// a change made after rendering stays pending
+		i.banOrUnban.CompareAndDelete(ip, p)
+	}
+	i.log.Printf("succesfully set limit: %v", limit)
+	i.log.Printf("succesfully set banned IPs: %v", banned)
+}
+
+func (i *impl) keepReconciling(ctx context.Context) {
+	defer i.wg.Done()
+	ticker := time.NewTicker(i.cfg.ReconcilePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-i.changed:
+		}
+		err := i.Reconcile(ctx)
+		if err != nil {
+			i.log.Println("failed to reconcile the gateway config:", err)
+		}
+	}
+}
+
+// Reconcile brings the gateway and the firewall in line with the limit and bans. It runs
+// periodically, and for a pushed gateway or a firewall also whenever they change.
+func (i *impl) Reconcile(ctx context.Context) error {
+	var err error
+	if i.gateway == nil {
+		err = i.reconcileTraefik(ctx)
+	} else if !i.cfg.DryRun {
+		err = i.push(ctx)
+	}
+	return errors.Join(err, i.reconcileFirewall(ctx))
+}
+
+// push applies the limit and bans to the gateway. They are applied on every reconciliation,
+// so a gateway that lost them, like HAProxy after a reload, gets them back.
+func (i *impl) push(ctx context.Context) error {
+	i.pushLock.Lock()
+	defer i.pushLock.Unlock()
+
+	limit, banned, pending := i.desiredGateway()
+	ctx, cancel := context.WithTimeout(ctx, i.cfg.ReconcilePeriod)
+	defer cancel()
+	err := i.gateway.Apply(ctx, limit, banned)
+	if err != nil {
+		return err
+	}
+	changed := i.pushed == nil || limit != i.pushedLimit || !slices.Equal(banned, i.pushed)
+	i.pushed, i.pushedLimit = banned, limit
+	if changed || len(pending) > 0 {
+		i.applied(limit, banned, pending)
+	}
+	return nil
+}
+
+// notify wakes up the reconciliation of a pushed gateway.
+func (i *impl) notify() {
+	select {
+	case i.changed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,138 @@
+package execute
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// HAProxyConfig configures the HAProxy gateway, which is changed through the Runtime API.
+// Banned addresses are the keys of BanMap, and LimitMap maps LimitKey to the limit, for a
+// frontend that denies the sources whose request rate in a stick table exceeds it.
+// The rate limit exempt sources are the keys of ExemptMap, which the frontend leaves out of the limit.
+type HAProxyConfig struct {
+	// Socket is the path of the Runtime API's unix socket, which needs the admin level.
+	Socket    string `config:"socket"`
+	BanMap    string `config:"ban_map"`
+	LimitMap  string `config:"limit_map"`
+	LimitKey  string `config:"limit_key"`
+	ExemptMap string `config:"exempt_map"`
+}
+
+type haproxyGateway struct {
+	cfg    HAProxyConfig
+	exempt []string
+	dialer net.Dialer
+}
+
+func NewHAProxyGateway(cfg HAProxyConfig, exempt []string) Gateway {
+	return &haproxyGateway{cfg: cfg, exempt: exempt}
+}
+
+// command runs a Runtime API command, each on its own connection, and returns its output.
+func (h *haproxyGateway) command(ctx context.Context, format string, args ...any) (string, error) {
+	conn, err := h.dialer.DialContext(ctx, "unix", h.cfg.Socket)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	_, err = fmt.Fprintf(conn, format+"\n", args...)
+	if err != nil {
+		return "", err
+	}
+	out, err := io.ReadAll(conn)
+	return string(out), err
+}
+
+// change runs a command that only has an output if it fails.
+func (h *haproxyGateway) change(ctx context.Context, format string, args ...any) error {
+	out, err := h.command(ctx, format, args...)
+	if err != nil {
+		return err
+	}
+	if out = strings.TrimSpace(out); out != "" {
+		return fmt.Errorf("%s: %s", fmt.Sprintf(format, args...), out)
+	}
+	return nil
+}
+
+// showMap returns the entries of a map.
+func (h *haproxyGateway) showMap(ctx context.Context, name string) (map[string]string, error) {
+	out, err := h.command(ctx, "show map %s", name)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// each entry is listed as "<id> <key> <value>"
+		fields := strings.Fields(line)
+		if len(fields) != 3 || !strings.HasPrefix(fields[0], "0x") {
+			return nil, fmt.Errorf("show map %s: %s", name, line)
+		}
+		entries[fields[1]] = fields[2]
+	}
+	return entries, scanner.Err()
+}
+
+func (h *haproxyGateway) Apply(ctx context.Context, limit int, banned []string) error {
+	limits, err := h.showMap(ctx, h.cfg.LimitMap)
+	if err != nil {
+		return err
+	}
+	value := strconv.Itoa(limit)
+	if current, ok := limits[h.cfg.LimitKey]; !ok {
+		err = h.change(ctx, "add map %s %s %s", h.cfg.LimitMap, h.cfg.LimitKey, value)
+	} else if current != value {
+		err = h.change(ctx, "set map %s %s %s", h.cfg.LimitMap, h.cfg.LimitKey, value)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = h.syncKeys(ctx, h.cfg.BanMap, banned)
+	if err != nil {
+		return err
+	}
+	if h.cfg.ExemptMap == "" {
+		return nil
+	}
+	return h.syncKeys(ctx, h.cfg.ExemptMap, h.exempt)
+}
+
+// syncKeys adds the missing keys to a map, and deletes the ones that are not in keys.
+func (h *haproxyGateway) syncKeys(ctx context.Context, name string, keys []string) error {
+	entries, err := h.showMap(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, ok := entries[key]; ok {
+			delete(entries, key)
+			continue
+		}
+		err = h.change(ctx, "add map %s %s 1", name, key)
+		if err != nil {
+			return err
+		}
+	}
+	for key := range entries {
+		err = h.change(ctx, "del map %s %s", name, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package execute
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// haproxyStub serves the map commands of the Runtime API on a unix socket.
+type haproxyStub struct {
+	lock     sync.Mutex
+	maps     map[string]map[string]string
+	commands []string
+}
+
+func newHAProxyStub(t *testing.T) (*haproxyStub, string) {
+	socket := filepath.Join(t.TempDir(), "admin.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	s := &haproxyStub{maps: map[string]map[string]string{
+		"limit.map":  {},
+		"banned.map": {},
+		"exempt.map": {},
+	}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			_, _ = fmt.Fprint(conn, s.run(strings.TrimSpace(line)))
+			_ = conn.Close()
+		}
+	}()
+	return s, socket
+}
+
+func (s *haproxyStub) run(command string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fields := strings.Fields(command)
+	if len(fields) < 3 || fields[1] != "map" {
+		return "Unknown command.\n"
+	}
+	m, ok := s.maps[fields[2]]
+	if !ok {
+		return "Unknown map identifier.\n"
+	}
+	if fields[0] != "show" {
+		s.commands = append(s.commands, command)
+	}
+
+	switch {
+	case fields[0] == "show":
+		var out strings.Builder
+		id := 0x55d0
+		for _, key := range sortedKeys(m) {
+			fmt.Fprintf(&out, "0x%x %s %s\n", id, key, m[key])
+			id += 0x10
+		}
+		return out.String() + "\n"
+	case fields[0] == "add" && len(fields) == 5:
+		m[fields[3]] = fields[4]
+	case fields[0] == "set" && len(fields) == 5:
+		if _, ok := m[fields[3]]; !ok {
+			return "entry not found.\n"
+		}
+		m[fields[3]] = fields[4]
+	case fields[0] == "del" && len(fields) == 4:
+		if _, ok := m[fields[3]]; !ok {
+			return "Key not found.\n"
+		}
+		delete(m, fields[3])
+	default:
+		return "Unknown command.\n"
+	}
+	return "\n"
+}
+
+func (s *haproxyStub) changes() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	commands := s.commands
+	s.commands = nil
+	sort.Strings(commands)
+	return commands
+}
+
+func newTestHAProxy(socket string) *haproxyGateway {
+	return &haproxyGateway{cfg: HAProxyConfig{
+		Socket:   socket,
+		BanMap:   "banned.map",
+		LimitMap: "limit.map",
+		LimitKey: "limit",
+	}}
+}
+
+func TestHAProxyShowMap(t *testing.T) {
+	stub, socket := newHAProxyStub(t)
+	stub.maps["banned.map"] = map[string]string{"1.2.3.4": "1", "10.0.0.0/8": "1"}
+	h := newTestHAProxy(socket)
+
+	entries, err := h.showMap(context.Background(), "banned.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries["1.2.3.4"] != "1" || entries["10.0.0.0/8"] != "1" {
+		t.Errorf("entries = %v", entries)
+	}
+
+	_, err = h.showMap(context.Background(), "missing.map")
+	if err == nil || !strings.Contains(err.Error(), "Unknown map identifier.") {
+		t.Errorf("error of an unknown map = %v", err)
+	}
+}
+
+func TestHAProxyApply(t *testing.T) {
+	stub, socket := newHAProxyStub(t)
+	h := newTestHAProxy(socket)
+
+	err := h.Apply(context.Background(), 30, []string{"1.2.3.4", "5.6.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"add map banned.map 1.2.3.4 1",
+		"add map banned.map 5.6.0.0/16 1",
+		"add map limit.map limit 30",
+	}
+	if got := stub.changes(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("first commands = %q, want %q", got, want)
+	}
+
+	err = h.Apply(context.Background(), 20, []string{"5.6.0.0/16", "7.7.7.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"add map banned.map 7.7.7.7 1",
+		"del map banned.map 1.2.3.4",
+		"set map limit.map limit 20",
+	}
+	if got := stub.changes(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("second commands = %q, want %q", got, want)
+	}
+
+	err = h.Apply(context.Background(), 20, []string{"7.7.7.7", "5.6.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.changes(); len(got) != 0 {
+		t.Errorf("unchanged state ran %q", got)
+	}
+}
+
+func TestHAProxyApplyUnknownMap(t *testing.T) {
+	stub, socket := newHAProxyStub(t)
+	stub.maps["limit.map"]["limit"] = "30"
+	h := newTestHAProxy(socket)
+	h.cfg.BanMap = "missing.map"
+
+	err := h.Apply(context.Background(), 30, []string{"1.2.3.4"})
+	if err == nil || !strings.Contains(err.Error(), "show map missing.map") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestHAProxyApplyExemptMap(t *testing.T) {
+	stub, socket := newHAProxyStub(t)
+	stub.maps["limit.map"]["limit"] = "30"
+	stub.maps["exempt.map"]["9.9.9.9"] = "1"
+	h := newTestHAProxy(socket)
+	h.cfg.ExemptMap = "exempt.map"
+	h.exempt = []string{"10.0.0.0/8", "1.2.3.4"}
+
+	err := h.Apply(context.Background(), 30, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"add map exempt.map 1.2.3.4 1",
+		"add map exempt.map 10.0.0.0/8 1",
+		"del map exempt.map 9.9.9.9",
+	}
+	if got := stub.changes(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+
+	err = h.Apply(context.Background(), 30, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.changes(); len(got) != 0 {
+		t.Errorf("unchanged exempt sources ran %q", got)
+	}
+}
@@ -0,0 +1,198 @@
+package execute
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type KubernetesConfig struct {
+	Address            string `config:"address"`
+	Namespace          string `config:"namespace"`
+	Kind               string `config:"kind"`
+	Name               string `config:"name"`
+	TokenFile          string `config:"token_file"`
+	CAFile             string `config:"ca_file"`
+	InsecureSkipVerify bool   `config:"insecure_skip_verify"`
+}
+
+const (
+	KindDeployment  = "deployment"
+	KindStatefulSet = "statefulset"
+)
+
+// kubernetesOrchestrator scales a workload through the scale subresource of the Kubernetes API.
+type kubernetesOrchestrator struct {
+	cfg        KubernetesConfig
+	httpClient *http.Client
+}
+
+type kubernetesScale struct {
+	Spec struct {
+		Replicas int `json:"replicas"`
+	} `json:"spec"`
+	Status struct {
+		Replicas int    `json:"replicas"`
+		Selector string `json:"selector"`
+	} `json:"status"`
+}
+
+type kubernetesPodList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			NodeName string `json:"nodeName"`
+		} `json:"spec"`
+		Status struct {
+			Phase      string `json:"phase"`
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+func NewKubernetesOrchestrator(cfg KubernetesConfig) (Orchestrator, error) {
+	if cfg.Kind != KindDeployment && cfg.Kind != KindStatefulSet {
+		return nil, fmt.Errorf("unknown kubernetes workload kind %q", cfg.Kind)
+	}
+	if cfg.Name == "" || cfg.Namespace == "" {
+		return nil, fmt.Errorf("kubernetes workload namespace and name are required")
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read kubernetes ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &kubernetesOrchestrator{
+		cfg: cfg,
+		httpClient: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+func (k *kubernetesOrchestrator) scalePath() string {
+	return fmt.Sprintf("/apis/apps/v1/namespaces/%s/%ss/%s/scale",
+		url.PathEscape(k.cfg.Namespace), k.cfg.Kind, url.PathEscape(k.cfg.Name))
+}
+
+func (k *kubernetesOrchestrator) getScale(ctx context.Context) (*kubernetesScale, error) {
+	var scale kubernetesScale
+	err := k.do(ctx, http.MethodGet, k.scalePath(), "", nil, &scale)
+	if err != nil {
+		return nil, err
+	}
+	return &scale, nil
+}
+
+func (k *kubernetesOrchestrator) Replicas(ctx context.Context) (int, error) {
+	scale, err := k.getScale(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return scale.Spec.Replicas, nil
+}
+
+func (k *kubernetesOrchestrator) Scale(ctx context.Context, replicas int) error {
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"replicas": replicas,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return k.do(ctx, http.MethodPatch, k.scalePath(), "application/merge-patch+json", patch, nil)
+}
+
+func (k *kubernetesOrchestrator) Instances(ctx context.Context) ([]Instance, error) {
+	scale, err := k.getScale(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if scale.Status.Selector == "" {
+		return nil, fmt.Errorf("%s %s has no pod selector", k.cfg.Kind, k.cfg.Name)
+	}
+
+	var pods kubernetesPodList
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods?labelSelector=%s",
+		url.PathEscape(k.cfg.Namespace), url.QueryEscape(scale.Status.Selector))
+	err = k.do(ctx, http.MethodGet, path, "", nil, &pods)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Instance, 0, len(pods.Items))
+	for _, p := range pods.Items {
+		ready := false
+		for _, c := range p.Status.Conditions {
+			if c.Type == "Ready" && c.Status == "True" {
+				ready = true
+			}
+		}
+		result = append(result, Instance{
+			ID:      p.Metadata.Name,
+			Node:    p.Spec.NodeName,
+			State:   p.Status.Phase,
+			Running: p.Status.Phase == "Running" && ready,
+		})
+	}
+	return result, nil
+}
+
+func (k *kubernetesOrchestrator) do(ctx context.Context, method, path, contentType string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(k.cfg.Address, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if k.cfg.TokenFile != "" {
+		// the token is read on every request since projected service account tokens are rotated
+		token, err := os.ReadFile(k.cfg.TokenFile)
+		if err != nil {
+			return fmt.Errorf("could not read kubernetes token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	res, err := k.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s %s: unexpected status %d: %s", method, path, res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
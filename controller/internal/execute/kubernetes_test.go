@@ -0,0 +1,199 @@
+package execute
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testScalePath = "/apis/apps/v1/namespaces/web/deployments/file-server/scale"
+
+// kubernetesStub serves the scale subresource of a deployment and the pods it selects.
+type kubernetesStub struct {
+	lock     sync.Mutex
+	replicas int
+	selector string
+	status   int
+	auth     string
+	patches  []string
+}
+
+func (s *kubernetesStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.auth = r.Header.Get("Authorization")
+	if s.status != 0 {
+		http.Error(w, "forbidden by the stub", s.status)
+		return
+	}
+
+	switch {
+	case r.URL.Path == testScalePath && r.Method == http.MethodGet:
+		var scale kubernetesScale
+		scale.Spec.Replicas = s.replicas
+		scale.Status.Replicas = s.replicas
+		scale.Status.Selector = s.selector
+		_ = json.NewEncoder(w).Encode(scale)
+	case r.URL.Path == testScalePath && r.Method == http.MethodPatch:
+		if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		s.patches = append(s.patches, string(body))
+		var scale kubernetesScale
+		_ = json.Unmarshal(body, &scale)
+		s.replicas = scale.Spec.Replicas
+		_ = json.NewEncoder(w).Encode(scale)
+	case r.URL.Path == "/api/v1/namespaces/web/pods" && r.URL.Query().Get("labelSelector") == s.selector:
+		_, _ = io.WriteString(w, `{"items": [
+{"metadata": {"name": "file-server-a"}, "spec": {"nodeName": "node-1"},
+ "status": {"phase": "Running", "conditions": [{"type": "Ready", "status": "True"}]}},
+{"metadata": {"name": "file-server-b"}, "spec": {"nodeName": "node-2"},
+ "status": {"phase": "Running", "conditions": [{"type": "Ready", "status": "False"}]}},
+{"metadata": {"name": "file-server-c"}, "status": {"phase": "Pending"}}
+]}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestKubernetes(t *testing.T, stub *kubernetesStub) Orchestrator {
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	o, err := NewKubernetesOrchestrator(KubernetesConfig{
+		Address:   server.URL,
+		Namespace: "web",
+		Kind:      KindDeployment,
+		Name:      "file-server",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestKubernetesScale(t *testing.T) {
+	stub := &kubernetesStub{replicas: 2, selector: "app=file-server"}
+	o := newTestKubernetes(t, stub)
+
+	replicas, err := o.Replicas(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas != 2 {
+		t.Errorf("replicas = %d, want 2", replicas)
+	}
+
+	err = o.Scale(context.Background(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.patches) != 1 || stub.patches[0] != `{"spec":{"replicas":5}}` {
+		t.Errorf("patches = %v", stub.patches)
+	}
+	replicas, err = o.Replicas(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas != 5 {
+		t.Errorf("replicas = %d after scaling, want 5", replicas)
+	}
+}
+
+func TestKubernetesInstances(t *testing.T) {
+	o := newTestKubernetes(t, &kubernetesStub{replicas: 3, selector: "app=file-server"})
+
+	instances, err := o.Instances(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Instance{
+		{ID: "file-server-a", Node: "node-1", State: "Running", Running: true},
+		{ID: "file-server-b", Node: "node-2", State: "Running", Running: false},
+		{ID: "file-server-c", State: "Pending", Running: false},
+	}
+	if len(instances) != len(want) {
+		t.Fatalf("instances = %+v", instances)
+	}
+	for i := range want {
+		if instances[i] != want[i] {
+			t.Errorf("instance %d = %+v, want %+v", i, instances[i], want[i])
+		}
+	}
+}
+
+func TestKubernetesErrors(t *testing.T) {
+	stub := &kubernetesStub{replicas: 2, status: http.StatusForbidden}
+	o := newTestKubernetes(t, stub)
+
+	_, err := o.Replicas(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unexpected status 403: forbidden by the stub") {
+		t.Errorf("Replicas error = %v", err)
+	}
+	err = o.Scale(context.Background(), 3)
+	if err == nil || !strings.Contains(err.Error(), "PATCH "+testScalePath) {
+		t.Errorf("Scale error = %v", err)
+	}
+
+	stub.status = 0
+	_, err = o.Instances(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "no pod selector") {
+		t.Errorf("Instances error = %v", err)
+	}
+}
+
+func TestKubernetesToken(t *testing.T) {
+	stub := &kubernetesStub{replicas: 1}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	cfg := KubernetesConfig{
+		Address:   server.URL,
+		Namespace: "web",
+		Kind:      KindDeployment,
+		Name:      "file-server",
+		TokenFile: tokenFile,
+	}
+	o, err := NewKubernetesOrchestrator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = o.Replicas(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "could not read kubernetes token") {
+		t.Errorf("error without a token file = %v", err)
+	}
+
+	err = os.WriteFile(tokenFile, []byte("secret\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = o.Replicas(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.auth != "Bearer secret" {
+		t.Errorf("Authorization = %q", stub.auth)
+	}
+}
+
+func TestKubernetesConfigValidation(t *testing.T) {
+	for _, cfg := range []KubernetesConfig{
+		{Namespace: "web", Kind: "daemonset", Name: "file-server"},
+		{Namespace: "web", Kind: KindStatefulSet},
+		{Namespace: "web", Kind: KindDeployment, Name: "file-server", CAFile: "/does/not/exist"},
+	} {
+		if _, err := NewKubernetesOrchestrator(cfg); err == nil {
+			t.Errorf("config %+v was accepted", cfg)
+		}
+	}
+}
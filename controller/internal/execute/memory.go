@@ -0,0 +1,48 @@
+package execute
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MemoryOrchestrator keeps the replica count in memory. It is meant for tests and
+// for running the controller without a container orchestrator.
+type MemoryOrchestrator struct {
+	lock     sync.Mutex
+	replicas int
+}
+
+func NewMemoryOrchestrator(replicas int) *MemoryOrchestrator {
+	return &MemoryOrchestrator{replicas: replicas}
+}
+
+func (m *MemoryOrchestrator) Replicas(context.Context) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.replicas, nil
+}
+
+func (m *MemoryOrchestrator) Scale(_ context.Context, replicas int) error {
+	if replicas < 0 {
+		return fmt.Errorf("invalid replicas: %d", replicas)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.replicas = replicas
+	return nil
+}
+
+func (m *MemoryOrchestrator) Instances(context.Context) ([]Instance, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	result := make([]Instance, 0, m.replicas)
+	for r := range m.replicas {
+		result = append(result, Instance{
+			ID:      fmt.Sprintf("memory-%d", r+1),
+			State:   "running",
+			Running: true,
+		})
+	}
+	return result, nil
+}
@@ -2,17 +2,10 @@ package execute
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/swarm"
-	"github.com/docker/docker/client"
 	"log"
 	"net/http"
-	"slices"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -22,198 +15,281 @@ type Module interface {
 	Start()
 	ScaleService(ctx context.Context, replicas int) error
 	SetRateLimit(limit int)
-	BanIP(ip string)
+	// Reconcile compares the configuration that the gateway runs with the one it was served.
+	Reconcile(ctx context.Context) error
+	BanIP(ip string, ban knowledge.Ban)
 	UnbanIP(ip string)
+	// Handler serves Traefik's dynamic configuration.
+	Handler() http.Handler
+	// DryRun reports whether changes are only recorded instead of being applied.
+	DryRun() bool
 	Stop()
 }
 
 type impl struct {
 	knowledgeBase knowledge.Base
-	dockerClient  *client.Client
+	orchestrator  Orchestrator
 	limit         atomic.Int32
-	banOrUnban    *sync.Map
-	log           *log.Logger
+	// banOrUnban holds the pendingBans that the gateway has not applied yet.
+	banOrUnban *sync.Map
+	stop       context.CancelFunc
+	wg         *sync.WaitGroup
+	cfg        Config
+	decisions  *decisionLog
+	log        *log.Logger
+
+	// gateway is the gateway that changes are pushed to, or nil if Traefik pulls them.
+	gateway  Gateway
+	firewall Firewall
+	changed  chan struct{}
+	// pushed and pushedLimit are what was last pushed to the gateway, pushed is nil before the first push.
+	pushLock    sync.Mutex
+	pushed      []string
+	pushedLimit int
+
+	// traefik confirms the served configurations, if its api address is set.
+	traefik     *traefikAPI
+	gatewayLock sync.Mutex
+	// latest is the latest configuration, fetched is the latest one fetched by Traefik,
+	// and confirmed is the generation that was last found active.
+	latest    *gatewayConfig
+	fetched   *gatewayConfig
+	confirmed uint64
+	// retries is the number of times in a row the served configuration was not active.
+	retries int
 }
 
 type Config struct {
-	InitialLimit int `config:"initial_limit"`
+	InitialLimit    int    `config:"initial_limit"`
+	Orchestrator    string `config:"orchestrator"`
+	ServiceName     string `config:"service_name"`
+	InitialReplicas int    `config:"initial_replicas"`
+	// Gateway is the gateway that enforces the limit and bans, traefik, nginx or haproxy.
+	Gateway string `config:"gateway"`
+	// Firewall additionally drops the traffic of banned addresses on the host, it is empty or nftables.
+	Firewall string `config:"firewall"`
+	// DryRun records the changes in the decision log instead of applying them.
+	DryRun          bool   `config:"dry_run"`
+	DecisionLogPath string `config:"decision_log_path"`
+	// RateLimitExempt are the sources that are left out of the rate limit. Traefik serves them with the
+	// rendered fs-rate-limit-exempt router, which forwards to RateLimitExemptService, like file-server@swarm,
+	// without the fs-rate-limit middleware. HAProxy needs the exempt map, see HAProxyConfig.
+	RateLimitExempt        []string `config:"rate_limit_exempt"`
+	RateLimitExemptService string   `config:"rate_limit_exempt_service"`
+	// TraefikAPIAddress is the address of Traefik's api, like http://gateway:8080. If set, changes are
+	// applied once the api shows them active, instead of once the configuration is served.
+	TraefikAPIAddress string `config:"traefik_api_address"`
+	// ReconcilePeriod is how often the active configuration is compared with the served one, and
+	// MaxReconcileRetries is how many times it is served again before a drift is alerted.
+	ReconcilePeriod     time.Duration `config:"reconcile_period"`
+	MaxReconcileRetries int           `config:"max_reconcile_retries"`
+
+	Kubernetes KubernetesConfig `config:"kubernetes"`
+	Nginx      NginxConfig      `config:"nginx"`
+	HAProxy    HAProxyConfig    `config:"haproxy"`
+	Nftables   NftablesConfig   `config:"nftables"`
 }
 
-func NewModule(config Config, k knowledge.Base) Module {
-	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		panic(err)
-	}
+const (
+	refreshPeriod = 10 * time.Second
+)
+
+// NewModule creates the execute module. The gateway is nil if Traefik pulls the configuration
+// from the handler, and the firewall is nil if bans are only enforced by the gateway.
+func NewModule(config Config, k knowledge.Base, o Orchestrator, g Gateway, f Firewall, clock utils.Clock) Module {
 	i := &impl{
 		knowledgeBase: k,
-		dockerClient:  dockerClient,
+		orchestrator:  o,
+		gateway:       g,
+		firewall:      f,
+		changed:       make(chan struct{}, 1),
 		banOrUnban:    &sync.Map{},
+		cfg:           config,
 		log:           utils.GetLogger("execute"),
 	}
+	var err error
+	i.decisions, err = newDecisionLog(config.DecisionLogPath, clock, i.log)
+	if err != nil {
+		i.log.Println("failed to open decision log, decisions are only logged:", err)
+		i.decisions, _ = newDecisionLog("", clock, i.log)
+	}
+	if config.DryRun {
+		i.log.Println("running in dry run mode, changes are recorded but not applied")
+	}
+	if g == nil && config.TraefikAPIAddress != "" {
+		i.traefik = newTraefikAPI(config.TraefikAPIAddress)
+	}
+
 	err = i.refreshReplicas()
 	if err != nil {
-		panic(fmt.Errorf("failed to get initial replicas: %w", err))
+		i.log.Println("failed to get initial replicas:", err)
 	}
-	i.SetRateLimit(config.InitialLimit)
-	i.knowledgeBase.SetLimit(config.InitialLimit)
+	// a limit restored by the knowledge base takes precedence over the initial limit
+	limit := k.CurrentLimit()
+	if limit == 0 {
+		limit = config.InitialLimit
+	}
+	i.limit.Store(int32(limit))
+	i.knowledgeBase.SetLimit(limit)
 
 	return i
 }
 
 func (i *impl) Start() {
-	go func() {
-		http.HandleFunc("/gateway", i.handleGatewayRequest)
-		err := http.ListenAndServe(":6041", nil)
-		if err != nil {
-			log.Fatal("Error starting HTTP server", err)
-		}
-	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	i.stop = cancel
+	i.wg = &sync.WaitGroup{}
+
+	if i.cfg.DryRun {
+		// the replicas are managed by someone else, so they are kept up to date
+		i.wg.Add(1)
+		go i.keepRefreshingReplicas(ctx)
+	} else if i.knowledgeBase.CurrentReplicas() == 0 {
+		i.wg.Add(1)
+		go i.retryRefreshReplicas(ctx)
+	}
+	if i.traefik != nil || i.gateway != nil || i.firewall != nil {
+		i.wg.Add(1)
+		go i.keepReconciling(ctx)
+	}
+
+	// only Traefik pulls its configuration, the other gateways get it pushed
+	if i.gateway == nil {
+		go func() {
+			err := http.ListenAndServe(":6041", i.Handler())
+			if err != nil {
+				log.Fatal("Error starting HTTP server", err)
+			}
+		}()
+	}
 }
 
 func (i *impl) Stop() {
+	i.stop()
+	i.wg.Wait()
+	i.decisions.close()
 }
 
-const (
-	serviceName = "file-server"
-)
+func (i *impl) DryRun() bool {
+	return i.cfg.DryRun
+}
 
-func (i *impl) findService(ctx context.Context) (*swarm.Service, error) {
-	services, err := i.dockerClient.ServiceList(ctx, types.ServiceListOptions{})
-	if err != nil {
-		return nil, err
-	}
-	idx := slices.IndexFunc(services, func(s swarm.Service) bool {
-		return strings.Contains(s.Spec.Name, serviceName)
-	})
+func (i *impl) retryRefreshReplicas(ctx context.Context) {
+	defer i.wg.Done()
+	ticker := time.NewTicker(refreshPeriod)
+	defer ticker.Stop()
 
-	if idx < 0 {
-		return nil, fmt.Errorf("service %s not found", serviceName)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := i.refreshReplicas()
+			if err != nil {
+				i.log.Println("failed to refresh replicas:", err)
+				continue
+			}
+			return
+		}
 	}
-	service := services[idx]
-	spec := service.Spec
-	if spec.Mode.Replicated == nil || spec.Mode.Replicated.Replicas == nil {
-		return nil, fmt.Errorf("replicated service %s not found", serviceName)
+}
+
+func (i *impl) keepRefreshingReplicas(ctx context.Context) {
+	defer i.wg.Done()
+	ticker := time.NewTicker(refreshPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := i.refreshReplicas()
+			if err != nil {
+				i.log.Println("failed to refresh replicas:", err)
+			}
+		}
 	}
-	return &service, nil
 }
 
 func (i *impl) refreshReplicas() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	s, err := i.findService(ctx)
+	r, err := i.orchestrator.Replicas(ctx)
 	if err != nil {
 		return err
 	}
 
-	r := int(*s.Spec.Mode.Replicated.Replicas)
 	i.knowledgeBase.SetReplicas(r)
 	i.log.Println("successfully refreshed replicas:", r)
 	return nil
 }
 
 func (i *impl) ScaleService(ctx context.Context, replicas int) error {
-	service, err := i.findService(ctx)
-	if err != nil {
-		return err
+	decision := Decision{DryRun: i.cfg.DryRun, Action: actionScale, Value: replicas, Current: i.knowledgeBase.CurrentReplicas()}
+	if i.cfg.DryRun {
+		i.decisions.record(decision)
+		return nil
 	}
 
-	// Update the service with the new replica count
-	serviceSpec := service.Spec
-	if serviceSpec.Mode.Replicated == nil {
-		return fmt.Errorf("replicated service %s not found", serviceName)
-	}
-	r := uint64(replicas)
-	serviceSpec.Mode.Replicated.Replicas = &r
-
-	_, err = i.dockerClient.ServiceUpdate(ctx, service.ID, service.Version, serviceSpec, types.ServiceUpdateOptions{})
+	err := i.orchestrator.Scale(ctx, replicas)
 	if err != nil {
+		decision.Error = err.Error()
+		i.decisions.record(decision)
 		return err
 	}
+	i.decisions.record(decision)
 
+	i.knowledgeBase.RecordScale(knowledge.Scale{From: decision.Current, To: replicas})
 	i.knowledgeBase.SetReplicas(replicas)
-	i.log.Printf("Service %s scaled to %d replicas", serviceName, replicas)
+	i.log.Printf("Service %s scaled to %d replicas", i.cfg.ServiceName, replicas)
 	return nil
 }
 
 func (i *impl) SetRateLimit(limit int) {
+	i.decisions.record(Decision{DryRun: i.cfg.DryRun, Action: actionLimit, Value: limit, Current: int(i.limit.Load())})
+	if i.cfg.DryRun {
+		return
+	}
 	i.limit.Store(int32(limit))
+	if limit != i.knowledgeBase.CurrentLimit() {
+		i.knowledgeBase.SetPendingLimitChange(true)
+	}
+	i.notify()
 }
 
-func (i *impl) BanIP(ip string) {
-	i.banOrUnban.Store(ip, true)
+type pendingBan struct {
+	ban bool
+	knowledge.Ban
 }
 
-func (i *impl) UnbanIP(ip string) {
-	i.banOrUnban.Store(ip, false)
+func (i *impl) BanIP(ip string, ban knowledge.Ban) {
+	d := Decision{DryRun: i.cfg.DryRun, Action: actionBan, IP: ip, Reason: ban.Reason}
+	if !ban.Permanent() {
+		d.Expiry = &ban.Expiry
+	}
+	i.decisions.record(d)
+	if i.cfg.DryRun {
+		return
+	}
+	i.banOrUnban.Store(ip, pendingBan{ban: true, Ban: ban})
+	i.notify()
 }
 
-func (i *impl) handleGatewayRequest(w http.ResponseWriter, _ *http.Request) {
-	limit := i.limit.Load()
-
-	i.knowledgeBase.RangeBannedIPs(func(oldBanned string, _ time.Time) {
-		_, ok := i.banOrUnban.Load(oldBanned)
-		if !ok {
-			i.banOrUnban.Store(oldBanned, true)
-		}
-	})
-	newBannedIPs := make([]string, 0)
-	i.banOrUnban.Range(func(ip, ban any) bool {
-		if ban.(bool) {
-			newBannedIPs = append(newBannedIPs, ip.(string))
-		}
-		return true
-	})
-
-	empty := false
-	if len(newBannedIPs) == 0 {
-		newBannedIPs = append(newBannedIPs, "11.0.0.0")
-		empty = true
-	}
-	response := map[string]any{
-		"http": map[string]any{
-			"middlewares": map[string]any{
-				"fs-rate-limit": map[string]any{
-					"rateLimit": map[string]any{
-						"average": limit,
-						"burst":   limit,
-						"period":  1,
-						"sourceCriterion": map[string]any{
-							"ipStrategy": map[string]any{
-								"depth": 1,
-							},
-						},
-					},
-				},
-				"fs-deny-ip": map[string]any{
-					"plugin": map[string]any{
-						"denyip": map[string]any{
-							"ipDenyList": newBannedIPs,
-						},
-					},
-				},
-			},
-		},
-	}
-	if empty {
-		newBannedIPs = nil
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(response)
-	if err != nil {
-		i.log.Println("Error encoding json, serving gateway config response", err)
+func (i *impl) UnbanIP(ip string) {
+	i.decisions.record(Decision{DryRun: i.cfg.DryRun, Action: actionUnban, IP: ip})
+	if i.cfg.DryRun {
 		return
 	}
-	i.knowledgeBase.SetLimit(int(limit))
-	i.banOrUnban.Range(func(ip, value any) bool {
-		if value.(bool) {
-			i.knowledgeBase.BanIP(ip.(string))
-		} else {
-			i.knowledgeBase.UnbanIP(ip.(string))
-		}
-		return true
-	})
-	i.log.Printf("succesfully set limit: %v", limit)
-	i.log.Printf("succesfully set banned IPs: %v", newBannedIPs)
+	i.banOrUnban.Store(ip, pendingBan{ban: false})
+	i.notify()
+}
+
+func (i *impl) Handler() http.Handler {
+	mux := http.NewServeMux()
+	if i.gateway == nil {
+		mux.HandleFunc("/gateway", i.handleGatewayRequest)
+	}
+	return mux
 }
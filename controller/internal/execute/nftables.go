@@ -0,0 +1,264 @@
+package execute
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
+	"log"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NftablesConfig configures the nftables firewall. The banned IPv4 and IPv6 addresses and prefixes
+// are the elements of two sets of the table, with timeouts until their bans expire. The sets need
+// the interval and timeout flags, and a chain of the table drops the sources in them.
+type NftablesConfig struct {
+	Family  string `config:"family"`
+	Table   string `config:"table"`
+	IPv4Set string `config:"ipv4_set"`
+	IPv6Set string `config:"ipv6_set"`
+	// Command is the nft command, like sudo nft.
+	Command []string `config:"command"`
+}
+
+type nftablesFirewall struct {
+	cfg       NftablesConfig
+	commander Commander
+	now       func() time.Time
+	log       *log.Logger
+}
+
+func NewNftablesFirewall(cfg NftablesConfig) Firewall {
+	return newNftablesFirewall(cfg, ExecCommander, time.Now)
+}
+
+func newNftablesFirewall(cfg NftablesConfig, commander Commander, now func() time.Time) *nftablesFirewall {
+	return &nftablesFirewall{
+		cfg:       cfg,
+		commander: commander,
+		now:       now,
+		log:       utils.GetLogger("nftables"),
+	}
+}
+
+// nftTolerance is how much the timeout of an element may differ from its ban's expiry before it is
+// added again, so the elements are not replaced on every reconciliation because of rounding.
+const nftTolerance = 10 * time.Second
+
+// element returns the set and the element of an address or prefix.
+func (f *nftablesFirewall) element(ip string) (set, element string, err error) {
+	prefix, err := netip.ParsePrefix(ip)
+	if err != nil {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid address or prefix %q", ip)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	prefix = prefix.Masked()
+	addr := prefix.Addr()
+	if addr.Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+
+	element = prefix.String()
+	if prefix.IsSingleIP() {
+		element = prefix.Addr().String()
+	}
+	if prefix.Addr().Is4() {
+		return f.cfg.IPv4Set, element, nil
+	}
+	return f.cfg.IPv6Set, element, nil
+}
+
+func (f *nftablesFirewall) nft(ctx context.Context, input []byte, args ...string) ([]byte, error) {
+	command := append(slices.Clone(f.cfg.Command), args...)
+	return f.commander.Run(ctx, input, command...)
+}
+
+// list returns the elements of the set, with the time until they expire, or zero if they do not.
+func (f *nftablesFirewall) list(ctx context.Context, set string) (map[string]time.Duration, error) {
+	out, err := f.nft(ctx, nil, "-j", "list", "set", f.cfg.Family, f.cfg.Table, set)
+	if err != nil {
+		return nil, err
+	}
+	var listing struct {
+		Nftables []struct {
+			Set *struct {
+				Elem []json.RawMessage `json:"elem"`
+			} `json:"set"`
+		} `json:"nftables"`
+	}
+	err = json.Unmarshal(out, &listing)
+	if err != nil {
+		return nil, err
+	}
+
+	elements := make(map[string]time.Duration)
+	for _, o := range listing.Nftables {
+		if o.Set == nil {
+			continue
+		}
+		for _, raw := range o.Set.Elem {
+			element, expires, err := parseNftElement(raw)
+			if err != nil {
+				f.log.Printf("skipping an element of set %s: %s", set, err)
+				continue
+			}
+			elements[element] = expires
+		}
+	}
+	return elements, nil
+}
+
+// parseNftElement parses an element of nft's json output, which is an address, a prefix, a range,
+// or any of them wrapped with its timeout and the seconds until it expires.
+func parseNftElement(raw json.RawMessage) (string, time.Duration, error) {
+	var wrapped struct {
+		Elem *struct {
+			Val     json.RawMessage `json:"val"`
+			Expires int64           `json:"expires"`
+		} `json:"elem"`
+	}
+	if json.Unmarshal(raw, &wrapped) == nil && wrapped.Elem != nil {
+		element, err := parseNftValue(wrapped.Elem.Val)
+		return element, time.Duration(wrapped.Elem.Expires) * time.Second, err
+	}
+	element, err := parseNftValue(raw)
+	return element, 0, err
+}
+
+func parseNftValue(raw json.RawMessage) (string, error) {
+	var addr string
+	if json.Unmarshal(raw, &addr) == nil {
+		return addr, nil
+	}
+	var value struct {
+		Prefix *struct {
+			Addr string `json:"addr"`
+			Len  int    `json:"len"`
+		} `json:"prefix"`
+		Range []string `json:"range"`
+	}
+	if json.Unmarshal(raw, &value) == nil {
+		if value.Prefix != nil {
+			return fmt.Sprintf("%s/%d", value.Prefix.Addr, value.Prefix.Len), nil
+		}
+		// nft lists merged intervals as ranges, which are written as first-last
+		if len(value.Range) == 2 {
+			return value.Range[0] + "-" + value.Range[1], nil
+		}
+	}
+	return "", fmt.Errorf("unsupported set element %s", raw)
+}
+
+// Apply adds the bans that are missing from the sets, or whose expiry changed, and deletes
+// the elements that are not banned anymore, in a single nft transaction.
+func (f *nftablesFirewall) Apply(ctx context.Context, bans map[string]knowledge.Ban) error {
+	now := f.now()
+	var errs []error
+	desired := map[string]map[string]time.Duration{
+		f.cfg.IPv4Set: {},
+		f.cfg.IPv6Set: {},
+	}
+	for ip, b := range bans {
+		set, element, err := f.element(ip)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var timeout time.Duration
+		if !b.Permanent() {
+			// nft timeouts are in whole seconds
+			timeout = b.Expiry.Sub(now).Truncate(time.Second)
+			if timeout < time.Second {
+				continue
+			}
+		}
+		desired[set][element] = timeout
+	}
+	for _, elements := range desired {
+		dropCovered(elements)
+	}
+
+	var script strings.Builder
+	for _, set := range []string{f.cfg.IPv4Set, f.cfg.IPv6Set} {
+		current, err := f.list(ctx, set)
+		if err != nil {
+			return err
+		}
+		for _, element := range sortedKeys(current) {
+			expires := current[element]
+			timeout, ok := desired[set][element]
+			if ok && !nftStale(expires, timeout) {
+				delete(desired[set], element)
+				continue
+			}
+			fmt.Fprintf(&script, "delete element %s %s %s { %s }\n", f.cfg.Family, f.cfg.Table, set, element)
+		}
+		for _, element := range sortedKeys(desired[set]) {
+			if timeout := desired[set][element]; timeout > 0 {
+				fmt.Fprintf(&script, "add element %s %s %s { %s timeout %ds }\n",
+					f.cfg.Family, f.cfg.Table, set, element, int64(timeout.Seconds()))
+			} else {
+				fmt.Fprintf(&script, "add element %s %s %s { %s }\n", f.cfg.Family, f.cfg.Table, set, element)
+			}
+		}
+	}
+	if script.Len() > 0 {
+		_, err := f.nft(ctx, []byte(script.String()), "-f", "-")
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// nftStale reports whether an element that expires after expires, or never if it is zero,
+// needs to be added again for the timeout.
+func nftStale(expires, timeout time.Duration) bool {
+	if expires == 0 || timeout == 0 {
+		return expires != timeout
+	}
+	return (expires - timeout).Abs() > nftTolerance
+}
+
+// dropCovered removes the elements that a prefix among them covers, as an interval set rejects
+// overlapping elements. A removed element is added again once the prefix expires.
+func dropCovered(elements map[string]time.Duration) {
+	var prefixes []netip.Prefix
+	for element := range elements {
+		if p, err := netip.ParsePrefix(element); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	for element := range elements {
+		p, err := netip.ParsePrefix(element)
+		if err != nil {
+			addr, err := netip.ParseAddr(element)
+			if err != nil {
+				continue
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		for _, q := range prefixes {
+			if q.Bits() < p.Bits() && q.Contains(p.Addr()) {
+				delete(elements, element)
+				break
+			}
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
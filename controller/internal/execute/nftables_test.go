@@ -0,0 +1,137 @@
+package execute
+
+import (
+	"context"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+	"testing"
+	"time"
+)
+
+const (
+	listBanned4 = `{"nftables": [{"metainfo": {"json_schema_version": 1}}, {"set": {"family": "inet", "name": "banned4",
+"table": "anti_dos", "type": "ipv4_addr", "flags": ["interval", "timeout"], "elem": [
+"9.9.9.9",
+{"elem": {"val": "1.2.3.4", "timeout": 300, "expires": 290}},
+{"elem": {"val": {"prefix": {"addr": "10.1.0.0", "len": 16}}, "timeout": 600, "expires": 100}},
+{"range": ["7.7.7.1", "7.7.7.9"]}
+]}}]}`
+	listBanned6 = `{"nftables": [{"set": {"family": "inet", "name": "banned6", "table": "anti_dos", "type": "ipv6_addr"}}]}`
+)
+
+func newTestNftables(c *fakeCommander, now time.Time) *nftablesFirewall {
+	cfg := NftablesConfig{
+		Family:  "inet",
+		Table:   "anti_dos",
+		IPv4Set: "banned4",
+		IPv6Set: "banned6",
+		Command: []string{"nft"},
+	}
+	return newNftablesFirewall(cfg, c, func() time.Time { return now })
+}
+
+func TestNftablesList(t *testing.T) {
+	c := &fakeCommander{outputs: map[string]string{"nft -j list set inet anti_dos banned4": listBanned4}}
+	f := newTestNftables(c, time.Now())
+
+	elements, err := f.list(context.Background(), "banned4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Duration{
+		"9.9.9.9":         0,
+		"1.2.3.4":         290 * time.Second,
+		"10.1.0.0/16":     100 * time.Second,
+		"7.7.7.1-7.7.7.9": 0,
+	}
+	if len(elements) != len(want) {
+		t.Fatalf("elements = %v, want %v", elements, want)
+	}
+	for element, expires := range want {
+		if got, ok := elements[element]; !ok || got != expires {
+			t.Errorf("element %s expires in %v, want %v", element, got, expires)
+		}
+	}
+}
+
+func TestNftablesApply(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	c := &fakeCommander{outputs: map[string]string{
+		"nft -j list set inet anti_dos banned4": listBanned4,
+		"nft -j list set inet anti_dos banned6": listBanned6,
+	}}
+	f := newTestNftables(c, now)
+
+	err := f.Apply(context.Background(), map[string]knowledge.Ban{
+		// unchanged, within the tolerance
+		"1.2.3.4": {Expiry: now.Add(295 * time.Second)},
+		// extended, so it is added again
+		"10.1.2.3/16": {Expiry: now.Add(time.Hour)},
+		"5.5.5.5":     {Expiry: now.Add(90*time.Second + 500*time.Millisecond)},
+		"2001:db8::1": {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `delete element inet anti_dos banned4 { 10.1.0.0/16 }
+delete element inet anti_dos banned4 { 7.7.7.1-7.7.7.9 }
+delete element inet anti_dos banned4 { 9.9.9.9 }
+add element inet anti_dos banned4 { 10.1.0.0/16 timeout 3600s }
+add element inet anti_dos banned4 { 5.5.5.5 timeout 90s }
+add element inet anti_dos banned6 { 2001:db8::1 }
+`
+	last := len(c.commands) - 1
+	if c.commands[last] != "nft -f -" {
+		t.Fatalf("commands = %v", c.commands)
+	}
+	if c.inputs[last] != want {
+		t.Errorf("script =\n%s\nwant\n%s", c.inputs[last], want)
+	}
+}
+
+func TestNftablesApplyDropsCoveredElements(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	c := &fakeCommander{outputs: map[string]string{
+		"nft -j list set inet anti_dos banned4": listBanned4,
+		"nft -j list set inet anti_dos banned6": listBanned6,
+	}}
+	f := newTestNftables(c, now)
+
+	err := f.Apply(context.Background(), map[string]knowledge.Ban{
+		"1.2.3.0/24":  {Expiry: now.Add(time.Hour)},
+		"1.2.3.4":     {Expiry: now.Add(295 * time.Second)},
+		"1.2.3.9":     {},
+		"10.1.0.0/16": {Expiry: now.Add(100 * time.Second)},
+		"10.1.5.0/24": {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `delete element inet anti_dos banned4 { 1.2.3.4 }
+delete element inet anti_dos banned4 { 7.7.7.1-7.7.7.9 }
+delete element inet anti_dos banned4 { 9.9.9.9 }
+add element inet anti_dos banned4 { 1.2.3.0/24 timeout 3600s }
+`
+	last := len(c.commands) - 1
+	if c.inputs[last] != want {
+		t.Errorf("script =\n%s\nwant\n%s", c.inputs[last], want)
+	}
+}
+
+func TestNftablesApplyUnchanged(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	c := &fakeCommander{outputs: map[string]string{
+		"nft -j list set inet anti_dos banned4": `{"nftables": [{"set": {"name": "banned4", "elem": ["9.9.9.9"]}}]}`,
+		"nft -j list set inet anti_dos banned6": listBanned6,
+	}}
+	f := newTestNftables(c, now)
+
+	err := f.Apply(context.Background(), map[string]knowledge.Ban{"9.9.9.9": {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, command := range c.commands {
+		if command == "nft -f -" {
+			t.Errorf("unchanged sets ran a transaction")
+		}
+	}
+}
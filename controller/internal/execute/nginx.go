@@ -0,0 +1,130 @@
+package execute
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// NginxConfig configures the nginx gateway. The rendered file is meant to be included in the http
+// block of nginx.conf, like the files in conf.d, so the limit and the bans apply to every server.
+// nginx sees the address of the client in $remote_addr; behind another proxy, set it with the realip module.
+type NginxConfig struct {
+	ConfigPath string `config:"config_path"`
+	// TestCommand checks the configuration before ReloadCommand loads it. A configuration
+	// that fails the test is replaced with the previous one.
+	TestCommand   []string `config:"test_command"`
+	ReloadCommand []string `config:"reload_command"`
+	// ZoneSize is the size of the shared memory zone that keeps the request rates of the sources.
+	ZoneSize string `config:"zone_size"`
+}
+
+type nginxGateway struct {
+	cfg       NginxConfig
+	exempt    []string
+	commander Commander
+	// loaded is the config that nginx was last reloaded with.
+	loaded []byte
+}
+
+func NewNginxGateway(cfg NginxConfig, exempt []string) Gateway {
+	return &nginxGateway{
+		cfg:       cfg,
+		exempt:    exempt,
+		commander: ExecCommander,
+	}
+}
+
+// render returns the limit_req and deny directives of the limit and the banned addresses.
+// The exempt sources get an empty key, which limit_req does not account.
+func (n *nginxGateway) render(limit int, banned []string) []byte {
+	var b bytes.Buffer
+	b.WriteString("# rendered by the adaptive anti dos controller, changes are overwritten\n\n")
+
+	key := "$binary_remote_addr"
+	if len(n.exempt) > 0 {
+		b.WriteString("geo $fs_rate_limit_exempt {\n    default 0;\n")
+		for _, e := range n.exempt {
+			fmt.Fprintf(&b, "    %s 1;\n", e)
+		}
+		b.WriteString("}\n")
+		b.WriteString("map $fs_rate_limit_exempt $fs_rate_limit_key {\n    0 $binary_remote_addr;\n    1 \"\";\n}\n")
+		key = "$fs_rate_limit_key"
+	}
+	if limit > 0 {
+		fmt.Fprintf(&b, "limit_req_zone %s zone=fs_rate_limit:%s rate=%dr/s;\n", key, n.cfg.ZoneSize, limit)
+		fmt.Fprintf(&b, "limit_req zone=fs_rate_limit burst=%d nodelay;\n", limit)
+		b.WriteString("limit_req_status 429;\n")
+	}
+
+	if len(banned) > 0 {
+		b.WriteString("\n")
+	}
+	for _, ip := range banned {
+		fmt.Fprintf(&b, "deny %s;\n", ip)
+	}
+	return b.Bytes()
+}
+
+func (n *nginxGateway) Apply(ctx context.Context, limit int, banned []string) error {
+	config := n.render(limit, banned)
+	if bytes.Equal(n.loaded, config) {
+		return nil
+	}
+	previous, err := os.ReadFile(n.cfg.ConfigPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	existed := err == nil
+	// the file may hold the config already, if loading it failed before
+	written := !existed || !bytes.Equal(previous, config)
+	if written {
+		err = writeFileAtomic(n.cfg.ConfigPath, config)
+		if err != nil {
+			return err
+		}
+	}
+	if len(n.cfg.TestCommand) > 0 {
+		_, err = n.commander.Run(ctx, nil, n.cfg.TestCommand...)
+		if err != nil {
+			if written && existed {
+				err = errors.Join(err, writeFileAtomic(n.cfg.ConfigPath, previous))
+			} else if written {
+				err = errors.Join(err, os.Remove(n.cfg.ConfigPath))
+			}
+			return fmt.Errorf("nginx config test failed: %w", err)
+		}
+	}
+	_, err = n.commander.Run(ctx, nil, n.cfg.ReloadCommand...)
+	if err != nil {
+		return err
+	}
+	n.loaded = config
+	return nil
+}
+
+// writeFileAtomic replaces the file with the data, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package execute
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeCommander records the commands it runs, and fails the ones in fail.
+type fakeCommander struct {
+	commands []string
+	inputs   []string
+	fail     map[string]error
+	outputs  map[string]string
+}
+
+func (f *fakeCommander) Run(_ context.Context, input []byte, command ...string) ([]byte, error) {
+	c := strings.Join(command, " ")
+	f.commands = append(f.commands, c)
+	f.inputs = append(f.inputs, string(input))
+	return []byte(f.outputs[c]), f.fail[c]
+}
+
+func newTestNginx(t *testing.T, c Commander) *nginxGateway {
+	return &nginxGateway{
+		cfg: NginxConfig{
+			ConfigPath:    filepath.Join(t.TempDir(), "anti-dos.conf"),
+			TestCommand:   []string{"nginx", "-t"},
+			ReloadCommand: []string{"nginx", "-s", "reload"},
+			ZoneSize:      "10m",
+		},
+		commander: c,
+	}
+}
+
+func TestNginxApplyRendersAndReloads(t *testing.T) {
+	c := &fakeCommander{}
+	n := newTestNginx(t, c)
+
+	err := n.Apply(context.Background(), 30, []string{"1.2.3.4", "5.6.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := os.ReadFile(n.cfg.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"limit_req_zone $binary_remote_addr zone=fs_rate_limit:10m rate=30r/s;",
+		"limit_req zone=fs_rate_limit burst=30 nodelay;",
+		"deny 1.2.3.4;",
+		"deny 5.6.0.0/16;",
+	} {
+		if !strings.Contains(string(config), want) {
+			t.Errorf("config does not contain %q:\n%s", want, config)
+		}
+	}
+	if got := strings.Join(c.commands, ", "); got != "nginx -t, nginx -s reload" {
+		t.Errorf("commands = %s", got)
+	}
+
+	c.commands = nil
+	err = n.Apply(context.Background(), 30, []string{"1.2.3.4", "5.6.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.commands) != 0 {
+		t.Errorf("unchanged config ran %v", c.commands)
+	}
+}
+
+func TestNginxApplyReloadsAfterFailedReload(t *testing.T) {
+	c := &fakeCommander{fail: map[string]error{"nginx -s reload": errors.New("reload failed")}}
+	n := newTestNginx(t, c)
+
+	err := n.Apply(context.Background(), 30, nil)
+	if err == nil {
+		t.Fatal("expected the failed reload to be returned")
+	}
+
+	// the file already holds the config, but nginx has not loaded it
+	c.fail, c.commands = nil, nil
+	err = n.Apply(context.Background(), 30, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(c.commands, ", "); got != "nginx -t, nginx -s reload" {
+		t.Errorf("commands = %s", got)
+	}
+}
+
+func TestNginxApplyRestoresConfigFailingTest(t *testing.T) {
+	c := &fakeCommander{}
+	n := newTestNginx(t, c)
+	err := n.Apply(context.Background(), 30, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(n.cfg.ConfigPath)
+
+	c.fail = map[string]error{"nginx -t": errors.New("invalid")}
+	err = n.Apply(context.Background(), 40, nil)
+	if err == nil {
+		t.Fatal("expected the failed test to be returned")
+	}
+	after, _ := os.ReadFile(n.cfg.ConfigPath)
+	if string(before) != string(after) {
+		t.Errorf("config was not restored:\n%s", after)
+	}
+}
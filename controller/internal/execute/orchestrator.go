@@ -0,0 +1,43 @@
+package execute
+
+import (
+	"context"
+	"fmt"
+)
+
+// Orchestrator controls the replicas of the protected service.
+type Orchestrator interface {
+	Replicas(ctx context.Context) (int, error)
+	Scale(ctx context.Context, replicas int) error
+	Instances(ctx context.Context) ([]Instance, error)
+}
+
+type Instance struct {
+	ID      string
+	Node    string
+	State   string
+	Running bool
+}
+
+const (
+	OrchestratorSwarm      = "swarm"
+	OrchestratorMemory     = "memory"
+	OrchestratorKubernetes = "kubernetes"
+)
+
+func NewOrchestrator(cfg Config) (Orchestrator, error) {
+	switch cfg.Orchestrator {
+	case OrchestratorSwarm, "":
+		return NewSwarmOrchestrator(cfg.ServiceName)
+	case OrchestratorKubernetes:
+		kc := cfg.Kubernetes
+		if kc.Name == "" {
+			kc.Name = cfg.ServiceName
+		}
+		return NewKubernetesOrchestrator(kc)
+	case OrchestratorMemory:
+		return NewMemoryOrchestrator(cfg.InitialReplicas), nil
+	default:
+		return nil, fmt.Errorf("unknown orchestrator %q", cfg.Orchestrator)
+	}
+}
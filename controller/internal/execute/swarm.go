@@ -0,0 +1,99 @@
+package execute
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"slices"
+	"strings"
+)
+
+type swarmOrchestrator struct {
+	dockerClient *client.Client
+	serviceName  string
+}
+
+func NewSwarmOrchestrator(serviceName string) (Orchestrator, error) {
+	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	return &swarmOrchestrator{
+		dockerClient: dockerClient,
+		serviceName:  serviceName,
+	}, nil
+}
+
+func (s *swarmOrchestrator) findService(ctx context.Context) (*swarm.Service, error) {
+	services, err := s.dockerClient.ServiceList(ctx, types.ServiceListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	idx := slices.IndexFunc(services, func(service swarm.Service) bool {
+		return strings.Contains(service.Spec.Name, s.serviceName)
+	})
+
+	if idx < 0 {
+		return nil, fmt.Errorf("service %s not found", s.serviceName)
+	}
+	service := services[idx]
+	spec := service.Spec
+	if spec.Mode.Replicated == nil || spec.Mode.Replicated.Replicas == nil {
+		return nil, fmt.Errorf("replicated service %s not found", s.serviceName)
+	}
+	return &service, nil
+}
+
+func (s *swarmOrchestrator) Replicas(ctx context.Context) (int, error) {
+	service, err := s.findService(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int(*service.Spec.Mode.Replicated.Replicas), nil
+}
+
+func (s *swarmOrchestrator) Scale(ctx context.Context, replicas int) error {
+	service, err := s.findService(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Update the service with the new replica count
+	serviceSpec := service.Spec
+	r := uint64(replicas)
+	serviceSpec.Mode.Replicated.Replicas = &r
+
+	_, err = s.dockerClient.ServiceUpdate(ctx, service.ID, service.Version, serviceSpec, types.ServiceUpdateOptions{})
+	return err
+}
+
+func (s *swarmOrchestrator) Instances(ctx context.Context) ([]Instance, error) {
+	service, err := s.findService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := s.dockerClient.TaskList(ctx, types.TaskListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("service", service.ID),
+			filters.Arg("desired-state", string(swarm.TaskStateRunning)),
+		),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Instance, 0, len(tasks))
+	for _, t := range tasks {
+		result = append(result, Instance{
+			ID:      t.ID,
+			Node:    t.NodeID,
+			State:   string(t.Status.State),
+			Running: t.Status.State == swarm.TaskStateRunning,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,350 @@
+package execute
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// gatewayConfig is a version of the gateway's dynamic configuration,
+// along with the limit and pending bans that it applies.
+type gatewayConfig struct {
+	generation uint64
+	etag       string
+	body       []byte
+	// content is the configuration without its generation, which tells if two generations differ.
+	content []byte
+	limit   int
+	banned  []string
+	pending map[string]pendingBan
+	// stale configurations are served again as a new generation.
+	stale bool
+	// checks is the number of times the configuration was found not active.
+	checks int
+}
+
+const (
+	// deniedPlaceholder is served when no address is banned, as the denyip plugin needs a non-empty list.
+	deniedPlaceholder = "11.0.0.0"
+	// exemptRouterPriority is above the default priority, the length of the rule, of the other routers.
+	exemptRouterPriority = 1 << 30
+)
+
+// renderTraefik renders the middlewares of the limit and bans. The fs-generation middleware is not
+// used by any router, it makes each generation a distinct configuration, as Traefik ignores a
+// configuration identical to the one it runs, so serving one again makes Traefik load it again.
+// The rate limit exempt sources get the fs-rate-limit-exempt router, which matches them before the
+// other routers and leaves out fs-rate-limit. ClientIP matches the address Traefik receives the
+// request from, not X-Forwarded-For, so the exempt sources must reach Traefik directly.
+func (i *impl) renderTraefik(limit int, banned []string, generation uint64) ([]byte, error) {
+	denied := banned
+	if len(denied) == 0 {
+		denied = []string{deniedPlaceholder}
+	}
+	middlewares := map[string]any{
+		"fs-rate-limit": map[string]any{
+			"rateLimit": map[string]any{
+				"average": limit,
+				"burst":   limit,
+				"period":  1,
+				"sourceCriterion": map[string]any{
+					"ipStrategy": map[string]any{
+						"depth": 1,
+					},
+				},
+			},
+		},
+		"fs-deny-ip": map[string]any{
+			"plugin": map[string]any{
+				"denyip": map[string]any{
+					"ipDenyList": denied,
+				},
+			},
+		},
+	}
+	if generation > 0 {
+		middlewares["fs-generation"] = map[string]any{
+			"headers": map[string]any{
+				"customResponseHeaders": map[string]any{
+					"X-Config-Generation": fmt.Sprint(generation),
+				},
+			},
+		}
+	}
+	config := map[string]any{
+		"middlewares": middlewares,
+	}
+	if len(i.cfg.RateLimitExempt) > 0 {
+		rules := make([]string, len(i.cfg.RateLimitExempt))
+		for idx, source := range i.cfg.RateLimitExempt {
+			rules[idx] = fmt.Sprintf("ClientIP(`%s`)", source)
+		}
+		config["routers"] = map[string]any{
+			"fs-rate-limit-exempt": map[string]any{
+				"rule":        strings.Join(rules, " || "),
+				"priority":    exemptRouterPriority,
+				"service":     i.cfg.RateLimitExemptService,
+				"middlewares": []string{"fs-deny-ip"},
+			},
+		}
+	}
+	return json.Marshal(map[string]any{
+		"http": config,
+	})
+}
+
+// currentGateway returns the configuration of the desired state. A new generation
+// is started whenever the rendered configuration changes.
+func (i *impl) currentGateway() (*gatewayConfig, error) {
+	limit, banned, pending := i.desiredGateway()
+	content, err := i.renderTraefik(limit, banned, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	i.gatewayLock.Lock()
+	defer i.gatewayLock.Unlock()
+	if g := i.latest; g != nil && !g.stale && string(g.content) == string(content) {
+		// changes that do not alter the configuration, like a new expiry, are applied with it
+		g.pending = pending
+		return g, nil
+	}
+	var generation uint64 = 1
+	if i.latest != nil {
+		generation = i.latest.generation + 1
+		if string(i.latest.content) != string(content) {
+			i.retries = 0
+		}
+	}
+	body, err := i.renderTraefik(limit, banned, generation)
+	if err != nil {
+		return nil, err
+	}
+	// the hash keeps a restarted controller from matching the etags of its previous run
+	sum := sha256.Sum256(body)
+	i.latest = &gatewayConfig{
+		generation: generation,
+		etag:       fmt.Sprintf(`"%d-%x"`, generation, sum[:6]),
+		body:       body,
+		content:    content,
+		limit:      limit,
+		banned:     banned,
+		pending:    pending,
+	}
+	return i.latest, nil
+}
+
+// handleGatewayRequest serves the configuration to Traefik's http provider. Requests with
+// the etag of the current generation in If-None-Match are answered with 304 Not Modified.
+func (i *impl) handleGatewayRequest(w http.ResponseWriter, r *http.Request) {
+	g, err := i.currentGateway()
+	if err != nil {
+		i.log.Println("Error encoding json, serving gateway config response", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", g.etag)
+	w.Header().Set("X-Config-Generation", fmt.Sprint(g.generation))
+	if r.Header.Get("If-None-Match") == g.etag {
+		w.WriteHeader(http.StatusNotModified)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(g.body)
+		if err != nil {
+			i.log.Println("Error serving gateway config response", err)
+			return
+		}
+	}
+	i.served(g)
+}
+
+// served records that the gateway fetched the configuration. Without the gateway's api
+// to confirm it, the configuration is assumed to be applied once it is served.
+func (i *impl) served(g *gatewayConfig) {
+	if i.traefik == nil {
+		i.appliedConfig(g)
+		return
+	}
+	i.gatewayLock.Lock()
+	active := g.generation <= i.confirmed && i.retries == 0
+	if i.fetched == nil || g.generation > i.fetched.generation {
+		i.fetched = g
+	}
+	i.gatewayLock.Unlock()
+	if active {
+		// only changes that do not alter the active configuration are new
+		i.appliedConfig(g)
+	}
+}
+
+// appliedConfig commits the changes of a configuration that Traefik applied.
+func (i *impl) appliedConfig(g *gatewayConfig) {
+	i.gatewayLock.Lock()
+	pending := g.pending
+	i.gatewayLock.Unlock()
+	i.applied(g.limit, g.banned, pending)
+}
+
+// reconcileTraefik compares the configuration that Traefik runs with the latest served one.
+// The changes of a served configuration are applied once it is active. A configuration that
+// is still not active on its second check, or that the gateway drifted from, is served again as
+// a new generation, which Traefik loads again on its next poll. After MaxReconcileRetries retries
+// in a row, an alert is logged.
+func (i *impl) reconcileTraefik(ctx context.Context) error {
+	if i.traefik == nil {
+		return nil
+	}
+	i.gatewayLock.Lock()
+	g := i.fetched
+	i.gatewayLock.Unlock()
+	if g == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, i.cfg.ReconcilePeriod)
+	defer cancel()
+	active, err := i.traefik.active(ctx)
+	if err != nil {
+		return err
+	}
+
+	i.gatewayLock.Lock()
+	if active.matches(g.limit, g.banned) {
+		confirmed := g.generation > i.confirmed
+		if confirmed {
+			i.confirmed = g.generation
+		}
+		retries := i.retries
+		i.retries = 0
+		i.gatewayLock.Unlock()
+
+		if retries > 0 {
+			i.log.Printf("gateway config generation %d is active after %d failed checks", g.generation, retries)
+		}
+		if confirmed {
+			i.log.Printf("gateway config generation %d is active", g.generation)
+			i.appliedConfig(g)
+		}
+		return nil
+	}
+
+	drifted := g.generation <= i.confirmed
+	g.checks++
+	retry := drifted || g.checks > 1
+	if retry {
+		i.retries++
+		if i.retries <= i.cfg.MaxReconcileRetries && i.latest == g {
+			i.latest.stale = true
+		}
+	}
+	retries := i.retries
+	i.gatewayLock.Unlock()
+
+	if active.limit != g.limit {
+		i.knowledgeBase.SetPendingLimitChange(true)
+	}
+	switch {
+	case !retry:
+		i.log.Printf("gateway config generation %d is not active yet", g.generation)
+	case retries <= i.cfg.MaxReconcileRetries:
+		i.log.Printf("gateway runs limit %d and banned IPs %v instead of generation %d, serving it again (retry %d)",
+			active.limit, active.banned, g.generation, retries)
+	case retries == i.cfg.MaxReconcileRetries+1:
+		i.log.Printf("ALERT: gateway still runs limit %d and banned IPs %v instead of limit %d and banned IPs %v after %d retries",
+			active.limit, active.banned, g.limit, g.banned, i.cfg.MaxReconcileRetries)
+	}
+	return nil
+}
+
+// traefikAPI reads the active dynamic configuration from Traefik's api.
+type traefikAPI struct {
+	address string
+	client  *http.Client
+}
+
+func newTraefikAPI(address string) *traefikAPI {
+	return &traefikAPI{
+		address: strings.TrimSuffix(address, "/"),
+		client:  &http.Client{},
+	}
+}
+
+type traefikMiddleware struct {
+	Name      string `json:"name"`
+	Provider  string `json:"provider"`
+	Status    string `json:"status"`
+	RateLimit *struct {
+		Average int64 `json:"average"`
+		Burst   int64 `json:"burst"`
+	} `json:"rateLimit,omitempty"`
+	Plugin map[string]struct {
+		IPDenyList []string `json:"ipDenyList"`
+	} `json:"plugin,omitempty"`
+}
+
+// activeGateway is the limit and banned addresses of the middlewares that Traefik is running.
+type activeGateway struct {
+	limit  int
+	banned []string
+}
+
+func (a activeGateway) matches(limit int, banned []string) bool {
+	return a.limit == limit && slices.Equal(a.banned, banned)
+}
+
+func (t *traefikAPI) middlewares(ctx context.Context) ([]traefikMiddleware, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.address+"/api/http/middlewares?search=fs-&per_page=100", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var middlewares []traefikMiddleware
+	err = json.NewDecoder(resp.Body).Decode(&middlewares)
+	return middlewares, err
+}
+
+// active returns the state of the fs-rate-limit and fs-deny-ip middlewares of the http provider.
+func (t *traefikAPI) active(ctx context.Context) (activeGateway, error) {
+	middlewares, err := t.middlewares(ctx)
+	if err != nil {
+		return activeGateway{}, err
+	}
+
+	var a activeGateway
+	var rateLimit, denyIP bool
+	for _, m := range middlewares {
+		if m.Provider != "http" || m.Status != "enabled" {
+			continue
+		}
+		switch strings.TrimSuffix(m.Name, "@http") {
+		case "fs-rate-limit":
+			if m.RateLimit != nil {
+				a.limit, rateLimit = int(m.RateLimit.Average), true
+			}
+		case "fs-deny-ip":
+			if p, ok := m.Plugin["denyip"]; ok {
+				a.banned, denyIP = p.IPDenyList, true
+			}
+		}
+	}
+	if !rateLimit || !denyIP {
+		return activeGateway{}, fmt.Errorf("fs-rate-limit or fs-deny-ip middleware is not enabled")
+	}
+	a.banned = slices.DeleteFunc(slices.Clone(a.banned), func(ip string) bool {
+		return ip == deniedPlaceholder
+	})
+	slices.Sort(a.banned)
+	return a, nil
+}
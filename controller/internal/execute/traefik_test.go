@@ -0,0 +1,260 @@
+package execute
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// traefikStub serves the middlewares that the stubbed Traefik runs, like /api/http/middlewares.
+type traefikStub struct {
+	lock   sync.Mutex
+	limit  int
+	banned []string
+}
+
+func (s *traefikStub) set(limit int, banned ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.limit, s.banned = limit, banned
+}
+
+func (s *traefikStub) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	banned := s.banned
+	if len(banned) == 0 {
+		banned = []string{deniedPlaceholder}
+	}
+	_ = json.NewEncoder(w).Encode([]map[string]any{
+		{
+			"name":      "fs-rate-limit@http",
+			"provider":  "http",
+			"status":    "enabled",
+			"rateLimit": map[string]any{"average": s.limit, "burst": s.limit},
+		},
+		{
+			"name":     "fs-deny-ip@http",
+			"provider": "http",
+			"status":   "enabled",
+			"plugin":   map[string]any{"denyip": map[string]any{"ipDenyList": banned}},
+		},
+	})
+}
+
+func newTestTraefik(t *testing.T) (*impl, knowledge.Base, *traefikStub) {
+	stub := &traefikStub{limit: 50}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	k := knowledge.NewInMemoryBase(10, utils.SystemClock)
+	cfg := Config{
+		InitialLimit:        50,
+		TraefikAPIAddress:   server.URL,
+		ReconcilePeriod:     time.Second,
+		MaxReconcileRetries: 2,
+	}
+	e := NewModule(cfg, k, NewMemoryOrchestrator(1), nil, nil, utils.SystemClock).(*impl)
+	return e, k, stub
+}
+
+type servedConfig struct {
+	code       int
+	etag       string
+	generation string
+	body       string
+	limit      int
+}
+
+func fetch(t *testing.T, e *impl, etag string) servedConfig {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/gateway", nil)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	rec := httptest.NewRecorder()
+	e.Handler().ServeHTTP(rec, req)
+
+	c := servedConfig{
+		code:       rec.Code,
+		etag:       rec.Header().Get("ETag"),
+		generation: rec.Header().Get("X-Config-Generation"),
+		body:       rec.Body.String(),
+	}
+	if rec.Code == http.StatusOK {
+		var config struct {
+			HTTP struct {
+				Middlewares struct {
+					RateLimit struct {
+						RateLimit struct {
+							Average int `json:"average"`
+						} `json:"rateLimit"`
+					} `json:"fs-rate-limit"`
+				} `json:"middlewares"`
+			} `json:"http"`
+		}
+		err := json.Unmarshal(rec.Body.Bytes(), &config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.limit = config.HTTP.Middlewares.RateLimit.RateLimit.Average
+	}
+	return c
+}
+
+func reconcile(t *testing.T, e *impl) {
+	t.Helper()
+	err := e.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func banned(k knowledge.Base, ip string) bool {
+	found := false
+	k.RangeBannedIPs(func(b string, _ knowledge.Ban) {
+		found = found || b == ip
+	})
+	return found
+}
+
+func TestTraefikChangesAreAppliedOnceActive(t *testing.T) {
+	e, k, stub := newTestTraefik(t)
+	first := fetch(t, e, "")
+	reconcile(t, e)
+	if first.code != http.StatusOK || first.generation != "1" {
+		t.Fatalf("first config: %+v", first)
+	}
+
+	e.SetRateLimit(20)
+	e.BanIP("1.2.3.4", knowledge.Ban{Source: knowledge.BanSourceManual})
+	second := fetch(t, e, first.etag)
+	if second.code != http.StatusOK || second.generation != "2" || second.limit != 20 {
+		t.Fatalf("second config: %+v", second)
+	}
+	reconcile(t, e)
+	if k.CurrentLimit() != 50 || !k.HasPendingLimitChange() || banned(k, "1.2.3.4") {
+		t.Fatalf("changes were applied before traefik runs them")
+	}
+
+	stub.set(20, "1.2.3.4")
+	reconcile(t, e)
+	if k.CurrentLimit() != 20 || k.HasPendingLimitChange() || !banned(k, "1.2.3.4") {
+		t.Fatalf("changes were not applied once traefik runs them")
+	}
+
+	if c := fetch(t, e, second.etag); c.code != http.StatusNotModified {
+		t.Errorf("unchanged config got status %d", c.code)
+	}
+}
+
+func TestTraefikConfigIsServedAgainWhenNotActive(t *testing.T) {
+	e, k, stub := newTestTraefik(t)
+	fetch(t, e, "")
+	reconcile(t, e)
+
+	e.SetRateLimit(20)
+	second := fetch(t, e, "")
+	// the first check gives traefik time to load it, the second one retries
+	reconcile(t, e)
+	reconcile(t, e)
+
+	third := fetch(t, e, second.etag)
+	if third.code != http.StatusOK || third.generation != "3" || third.limit != 20 {
+		t.Fatalf("retried config: %+v", third)
+	}
+	// traefik only loads a config that differs from the one it runs
+	if third.body == second.body {
+		t.Errorf("retried config is identical to the one traefik ignored")
+	}
+
+	stub.set(20)
+	reconcile(t, e)
+	if k.CurrentLimit() != 20 {
+		t.Errorf("limit = %d after the retried config is active", k.CurrentLimit())
+	}
+}
+
+func TestTraefikDriftIsServedAgain(t *testing.T) {
+	e, k, stub := newTestTraefik(t)
+	first := fetch(t, e, "")
+	reconcile(t, e)
+
+	// traefik lost the configuration, like after a restart with another provider's config
+	stub.set(80)
+	reconcile(t, e)
+	if !k.HasPendingLimitChange() {
+		t.Errorf("drifted limit is not pending")
+	}
+	second := fetch(t, e, first.etag)
+	if second.code != http.StatusOK || second.generation != "2" || second.limit != 50 {
+		t.Fatalf("config after drift: %+v", second)
+	}
+
+	stub.set(50)
+	reconcile(t, e)
+	if k.HasPendingLimitChange() {
+		t.Errorf("limit is still pending after traefik runs it again")
+	}
+}
+
+func TestTraefikRateLimitExemptRouter(t *testing.T) {
+	e, _, _ := newTestTraefik(t)
+	e.cfg.RateLimitExempt = []string{"10.0.0.0/8", "1.2.3.4"}
+	e.cfg.RateLimitExemptService = "file-server@swarm"
+
+	var config struct {
+		HTTP struct {
+			Routers map[string]struct {
+				Rule        string   `json:"rule"`
+				Priority    int      `json:"priority"`
+				Service     string   `json:"service"`
+				Middlewares []string `json:"middlewares"`
+			} `json:"routers"`
+		} `json:"http"`
+	}
+	err := json.Unmarshal([]byte(fetch(t, e, "").body), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := config.HTTP.Routers["fs-rate-limit-exempt"]
+	if !ok || len(config.HTTP.Routers) != 1 {
+		t.Fatalf("routers = %+v", config.HTTP.Routers)
+	}
+	if r.Rule != "ClientIP(`10.0.0.0/8`) || ClientIP(`1.2.3.4`)" || r.Priority != exemptRouterPriority || r.Service != "file-server@swarm" {
+		t.Errorf("router = %+v", r)
+	}
+	if len(r.Middlewares) != 1 || r.Middlewares[0] != "fs-deny-ip" {
+		t.Errorf("router middlewares = %v, want only fs-deny-ip", r.Middlewares)
+	}
+}
+
+func TestNewGatewayRateLimitExempt(t *testing.T) {
+	exempt := []string{"10.0.0.0/8"}
+	tests := []struct {
+		name  string
+		cfg   Config
+		valid bool
+	}{
+		{name: "traefik without exempt sources", cfg: Config{Gateway: GatewayTraefik}, valid: true},
+		{name: "traefik without the exempt service", cfg: Config{Gateway: GatewayTraefik, RateLimitExempt: exempt}},
+		{name: "traefik", cfg: Config{Gateway: GatewayTraefik, RateLimitExempt: exempt, RateLimitExemptService: "file-server@swarm"}, valid: true},
+		{name: "nginx", cfg: Config{Gateway: GatewayNginx, RateLimitExempt: exempt}, valid: true},
+		{name: "haproxy without the exempt map", cfg: Config{Gateway: GatewayHAProxy, RateLimitExempt: exempt}},
+		{name: "haproxy", cfg: Config{Gateway: GatewayHAProxy, RateLimitExempt: exempt, HAProxy: HAProxyConfig{ExemptMap: "exempt.map"}}, valid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewGateway(tt.cfg)
+			if (err == nil) != tt.valid {
+				t.Errorf("error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
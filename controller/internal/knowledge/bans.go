@@ -0,0 +1,50 @@
+package knowledge
+
+import (
+	"time"
+)
+
+type BanSource string
+
+const (
+	// BanSourceAuto is a ban by the analyzer.
+	BanSourceAuto BanSource = "auto"
+	// BanSourceManual is a ban by an operator.
+	BanSourceManual BanSource = "manual"
+)
+
+// Ban is an active ban of an address or prefix. A ban without an expiry is permanent.
+type Ban struct {
+	Since  time.Time `json:"since"`
+	Expiry time.Time `json:"expiry,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Source BanSource `json:"source"`
+}
+
+func (b Ban) Permanent() bool {
+	return b.Expiry.IsZero()
+}
+
+func (b Ban) expired(now time.Time) bool {
+	return !b.Permanent() && !now.Before(b.Expiry)
+}
+
+// rangeBans calls f for the active bans, and removes the expired ones, ending their offences at their expiry.
+// expired is called for each removed ban.
+func (i *impl) rangeBans(f func(string, Ban), expired func(string, Ban)) {
+	now := i.clock.Now()
+	i.bannedIPs.Range(func(k, v any) bool {
+		ip, b := k.(string), v.(Ban)
+		if !b.expired(now) {
+			f(ip, b)
+			return true
+		}
+		if i.bannedIPs.CompareAndDelete(ip, b) {
+			i.endOffence(ip, b.Expiry)
+			if expired != nil {
+				expired(ip, b)
+			}
+		}
+		return true
+	})
+}
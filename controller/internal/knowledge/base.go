@@ -1,6 +1,9 @@
 package knowledge
 
 import (
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,9 +18,40 @@ type Base interface {
 	SetPendingReplicaChange(bool)
 	HasPendingLimitChange() bool
 	HasPendingReplicaChange() bool
-	RangeBannedIPs(func(string, time.Time))
-	BanIP(ip string)
+	// Mode returns the current mode of the control loop, an expired mode is reported as ModeAuto.
+	Mode() Mode
+	SetMode(Mode)
+	// RangeBannedIPs calls f for each active ban. Bans are removed once they expire.
+	RangeBannedIPs(f func(string, Ban))
+	// BanIP bans an address or replaces its active ban. The ban starts now if Since is not set.
+	BanIP(ip string, ban Ban)
 	UnbanIP(ip string)
+	// Offences returns the latest bans of an address from oldest to newest, including the active one.
+	Offences(ip string) []Offence
+	// LastScale, LastScaleUp and LastScaleDown return the last executed scale in any, the up
+	// and the down direction. They are zero if there is none.
+	LastScale() Scale
+	LastScaleUp() Scale
+	LastScaleDown() Scale
+	// RecordScale records an executed scale. The scale happens now if its Time is not set.
+	RecordScale(s Scale)
+	// ReplicaStartup returns the smoothed time it takes new replicas to start, zero if it is not observed yet.
+	ReplicaStartup() time.Duration
+	RecordReplicaStartup(d time.Duration)
+
+	RecordReport(r monitor.Report)
+	RecordAdaptation(a Adaptation)
+	// LastReports returns the newest n reports from oldest to newest.
+	LastReports(n int) []monitor.Report
+	// LastAdaptations returns the newest n adaptations from oldest to newest.
+	LastAdaptations(n int) []Adaptation
+	RecordDecision(d Decision)
+	// LastDecisions returns the newest n analyzer decisions from oldest to newest.
+	LastDecisions(n int) []Decision
+	// AggregateReports summarizes the reports recorded during the last window.
+	AggregateReports(window time.Duration) ReportAggregate
+	// Close persists the state and releases the store, changes after it are not persisted.
+	Close() error
 }
 
 type impl struct {
@@ -25,11 +59,58 @@ type impl struct {
 	replicas             atomic.Int32
 	pendingReplicaChange atomic.Bool
 	pendingLimitChange   atomic.Bool
+	mode                 atomic.Pointer[Mode]
+	lastScaleUp          atomic.Pointer[Scale]
+	lastScaleDown        atomic.Pointer[Scale]
+	replicaStartup       atomic.Int64
 	bannedIPs            sync.Map
+	clock                utils.Clock
+	historyLock          sync.RWMutex
+	reports              ring[monitor.Report]
+	adaptations          ring[Adaptation]
+	decisions            ring[Decision]
+	offencesLock         sync.RWMutex
+	offences             map[string][]Offence
+}
+
+type Config struct {
+	Store       string `config:"store"`
+	Path        string `config:"path"`
+	HistorySize int    `config:"history_size"`
+}
+
+const (
+	StoreMemory = "memory"
+	StoreFile   = "file"
+)
+
+func NewBase(cfg Config, clock utils.Clock) (Base, error) {
+	switch cfg.Store {
+	case StoreMemory, "":
+		return NewInMemoryBase(cfg.HistorySize, clock), nil
+	case StoreFile:
+		return NewFileBase(cfg.Path, cfg.HistorySize, clock)
+	default:
+		return nil, fmt.Errorf("unknown knowledge store %q", cfg.Store)
+	}
+}
+
+func NewInMemoryBase(historySize int, clock utils.Clock) Base {
+	return newImpl(historySize, clock)
 }
 
-func NewInMemoryBase() Base {
-	return &impl{}
+func newImpl(historySize int, clock utils.Clock) *impl {
+	return &impl{
+		clock:       clock,
+		reports:     newRing[monitor.Report](historySize),
+		adaptations: newRing[Adaptation](historySize),
+		decisions:   newRing[Decision](historySize),
+		offences:    make(map[string][]Offence),
+	}
+}
+
+func (i *impl) Close() error {
+	return nil
 }
 
 func (i *impl) CurrentLimit() int {
@@ -66,17 +147,104 @@ func (i *impl) HasPendingLimitChange() bool {
 	return i.pendingLimitChange.Load()
 }
 
-func (i *impl) RangeBannedIPs(f func(string, time.Time)) {
-	i.bannedIPs.Range(func(k, v any) bool {
-		f(k.(string), v.(time.Time))
-		return true
-	})
+func (i *impl) Mode() Mode {
+	m := i.mode.Load()
+	if m == nil || m.expired(i.clock.Now()) {
+		return Mode{Kind: ModeAuto}
+	}
+	return *m
+}
+
+func (i *impl) SetMode(m Mode) {
+	if m.Kind == "" {
+		m.Kind = ModeAuto
+	}
+	i.mode.Store(&m)
+}
+
+func (i *impl) RangeBannedIPs(f func(string, Ban)) {
+	i.rangeBans(f, nil)
 }
 
-func (i *impl) BanIP(ip string) {
-	i.bannedIPs.LoadOrStore(ip, time.Now())
+func (i *impl) BanIP(ip string, ban Ban) {
+	i.ban(ip, i.withSince(ip, ban))
+}
+
+// withSince sets the start of a ban that does not have one, to the start of the active ban
+// of the address if it is being replaced, or to now.
+func (i *impl) withSince(ip string, ban Ban) Ban {
+	if !ban.Since.IsZero() {
+		return ban
+	}
+	now := i.clock.Now()
+	ban.Since = now
+	if v, ok := i.bannedIPs.Load(ip); ok && !v.(Ban).expired(now) {
+		ban.Since = v.(Ban).Since
+	}
+	return ban
 }
 
 func (i *impl) UnbanIP(ip string) {
-	i.bannedIPs.Delete(ip)
+	i.unban(ip, i.clock.Now())
+}
+
+// ban reports whether the ban has changed. A previous ban that expired by the start of the ban,
+// but was not removed yet, ends its offence at its expiry, and the ban starts a new offence.
+func (i *impl) ban(ip string, ban Ban) bool {
+	old, loaded := i.bannedIPs.Swap(ip, ban)
+	if loaded && old.(Ban).expired(ban.Since) {
+		i.endOffence(ip, old.(Ban).Expiry)
+		loaded = false
+	}
+	if !loaded {
+		i.startOffence(ip, ban.Since)
+		return true
+	}
+	return old.(Ban) != ban
+}
+
+// unban reports whether the address was banned before.
+func (i *impl) unban(ip string, t time.Time) bool {
+	if _, loaded := i.bannedIPs.LoadAndDelete(ip); !loaded {
+		return false
+	}
+	i.endOffence(ip, t)
+	return true
+}
+
+func (i *impl) RecordReport(r monitor.Report) {
+	i.historyLock.Lock()
+	defer i.historyLock.Unlock()
+	i.reports.push(r)
+}
+
+func (i *impl) RecordAdaptation(a Adaptation) {
+	if a.Time.IsZero() {
+		a.Time = i.clock.Now()
+	}
+	i.historyLock.Lock()
+	defer i.historyLock.Unlock()
+	i.adaptations.push(a)
+}
+
+func (i *impl) LastReports(n int) []monitor.Report {
+	i.historyLock.RLock()
+	defer i.historyLock.RUnlock()
+	return i.reports.last(n)
+}
+
+func (i *impl) LastAdaptations(n int) []Adaptation {
+	i.historyLock.RLock()
+	defer i.historyLock.RUnlock()
+	return i.adaptations.last(n)
+}
+
+func (i *impl) AggregateReports(window time.Duration) ReportAggregate {
+	since := i.clock.Now().Add(-window)
+	reports := i.LastReports(-1)
+	idx := 0
+	for idx < len(reports) && reports[idx].Time.Before(since) {
+		idx++
+	}
+	return aggregateReports(reports[idx:])
 }
@@ -0,0 +1,49 @@
+package knowledge
+
+import (
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBanIPOffences(t *testing.T) {
+	tests := []struct {
+		name     string
+		after    time.Duration
+		offences []Offence
+	}{
+		{
+			name:     "replacing an active ban keeps its offence",
+			after:    30 * time.Second,
+			offences: []Offence{{BannedAt: testStart}},
+		},
+		{
+			name:  "banning after an expired ban that was not removed starts a new offence",
+			after: 2 * time.Minute,
+			offences: []Offence{
+				{BannedAt: testStart, UnbannedAt: testStart.Add(time.Minute)},
+				{BannedAt: testStart.Add(2 * time.Minute)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "knowledge.log")
+			clock := utils.NewVirtualClock(testStart)
+			b := openTestFileBase(t, path, clock)
+			b.BanIP("1.1.1.1", Ban{Expiry: testStart.Add(time.Minute), Source: BanSourceAuto})
+			clock.Advance(tt.after)
+			b.BanIP("1.1.1.1", Ban{Expiry: clock.Now().Add(time.Hour), Source: BanSourceAuto})
+
+			if got := b.Offences("1.1.1.1"); !reflect.DeepEqual(got, tt.offences) {
+				t.Errorf("offences = %+v, want %+v", got, tt.offences)
+			}
+			_ = b.Close()
+			if got := openTestFileBase(t, path, clock).Offences("1.1.1.1"); !reflect.DeepEqual(got, tt.offences) {
+				t.Errorf("restored offences = %+v, want %+v", got, tt.offences)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package knowledge
+
+import (
+	"time"
+)
+
+// Decision records an analyzer cycle: the inputs it was based on, the intermediate values
+// of the policy, and the changes it chose.
+type Decision struct {
+	Time   time.Time      `json:"time"`
+	Mode   ModeKind       `json:"mode"`
+	Inputs DecisionInputs `json:"inputs"`
+	PolicyDecision
+	// Shadow is the decision of the shadow policy, which is not executed.
+	Shadow *PolicyDecision `json:"shadow,omitempty"`
+	Banned []string        `json:"banned,omitempty"`
+}
+
+// DecisionInputs are the measurements and the state that an analyzer cycle started with.
+type DecisionInputs struct {
+	CpuUtilization       float64 `json:"cpu_utilization"`
+	RunningReplicas      int     `json:"running_replicas"`
+	TotalRate            float64 `json:"total_rate"`
+	NonLimitedRate       float64 `json:"non_limited_rate"`
+	LimitedRatesStdDev   float64 `json:"limited_rates_std_dev"`
+	GoodLatencyPercent   float64 `json:"good_latency_percent"`
+	Limit                int     `json:"limit"`
+	Replicas             int     `json:"replicas"`
+	PendingLimitChange   bool    `json:"pending_limit_change,omitempty"`
+	PendingReplicaChange bool    `json:"pending_replica_change,omitempty"`
+}
+
+// PolicyDecision is the limit and replicas chosen by a policy, zero if they are not changed.
+type PolicyDecision struct {
+	Policy   string             `json:"policy"`
+	Limit    int                `json:"limit,omitempty"`
+	Replicas int                `json:"replicas,omitempty"`
+	Reason   string             `json:"reason,omitempty"`
+	Values   map[string]float64 `json:"values,omitempty"`
+}
+
+func (i *impl) RecordDecision(d Decision) {
+	if d.Time.IsZero() {
+		d.Time = i.clock.Now()
+	}
+	i.historyLock.Lock()
+	defer i.historyLock.Unlock()
+	i.decisions.push(d)
+}
+
+func (i *impl) LastDecisions(n int) []Decision {
+	i.historyLock.RLock()
+	defer i.historyLock.RUnlock()
+	return i.decisions.last(n)
+}
@@ -0,0 +1,347 @@
+package knowledge
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileBase is a Base that appends every change to a log file, and restores its state
+// from the file on startup. The log is compacted into a snapshot of the state when opened,
+// and once the records appended since outgrow both the snapshot and compactSize. The report,
+// adaptation and decision history is kept in memory only.
+type fileBase struct {
+	*impl
+	// lock is held while a change is made and appended, so the log has the order of the changes.
+	lock      sync.Mutex
+	path      string
+	file      *os.File
+	compacted int64
+	written   int64
+	log       *log.Logger
+}
+
+// compactSize is the least size of the records appended to the log before it is compacted.
+const compactSize = 1 << 20
+
+type record struct {
+	Op    string     `json:"op"`
+	Value int        `json:"value,omitempty"`
+	Flag  bool       `json:"flag,omitempty"`
+	IP    string     `json:"ip,omitempty"`
+	Time  *time.Time `json:"time,omitempty"`
+	Mode  *Mode      `json:"mode,omitempty"`
+	Ban   *Ban       `json:"ban,omitempty"`
+	Scale *Scale     `json:"scale,omitempty"`
+
+	Duration time.Duration `json:"duration,omitempty"`
+
+	Offences []Offence `json:"offences,omitempty"`
+}
+
+const (
+	opLimit                = "limit"
+	opReplicas             = "replicas"
+	opPendingLimitChange   = "pending_limit_change"
+	opPendingReplicaChange = "pending_replica_change"
+	opMode                 = "mode"
+	opBan                  = "ban"
+	opUnban                = "unban"
+	opOffences             = "offences"
+	opScale                = "scale"
+	opReplicaStartup       = "replica_startup"
+)
+
+func NewFileBase(path string, historySize int, clock utils.Clock) (Base, error) {
+	b := &fileBase{
+		impl: newImpl(historySize, clock),
+		path: path,
+		log:  utils.GetLogger("knowledge"),
+	}
+	err := b.restore()
+	if err != nil {
+		return nil, fmt.Errorf("could not restore knowledge base from %s: %w", path, err)
+	}
+	err = b.compact()
+	if err != nil {
+		return nil, fmt.Errorf("could not compact knowledge base file %s: %w", path, err)
+	}
+	return b, nil
+}
+
+func (b *fileBase) restore() error {
+	f, err := os.Open(b.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var r record
+		err := json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			// a partially written last line is expected after a crash
+			b.log.Printf("skipping invalid record on line %d: %s", line, err)
+			continue
+		}
+		b.apply(r)
+	}
+	return scanner.Err()
+}
+
+func (b *fileBase) apply(r record) {
+	switch r.Op {
+	case opLimit:
+		b.limit.Store(int32(r.Value))
+	case opReplicas:
+		b.replicas.Store(int32(r.Value))
+	case opPendingLimitChange:
+		b.pendingLimitChange.Store(r.Flag)
+	case opPendingReplicaChange:
+		b.pendingReplicaChange.Store(r.Flag)
+	case opMode:
+		if r.Mode != nil {
+			b.impl.SetMode(*r.Mode)
+		}
+	case opBan:
+		if r.Ban != nil {
+			b.ban(r.IP, *r.Ban)
+		}
+	case opUnban:
+		t := time.Time{}
+		if r.Time != nil {
+			t = *r.Time
+		}
+		b.unban(r.IP, t)
+	case opOffences:
+		b.setOffences(r.IP, r.Offences)
+	case opScale:
+		if r.Scale != nil {
+			b.impl.RecordScale(*r.Scale)
+		}
+	case opReplicaStartup:
+		b.setReplicaStartup(r.Duration)
+	default:
+		b.log.Printf("skipping unknown record %q", r.Op)
+	}
+}
+
+func (b *fileBase) snapshot() []record {
+	records := []record{
+		{Op: opLimit, Value: b.CurrentLimit()},
+		{Op: opReplicas, Value: b.CurrentReplicas()},
+		{Op: opPendingLimitChange, Flag: b.HasPendingLimitChange()},
+		{Op: opPendingReplicaChange, Flag: b.HasPendingReplicaChange()},
+	}
+	if m := b.Mode(); m.Kind != ModeAuto {
+		records = append(records, record{Op: opMode, Mode: &m})
+	}
+	for _, s := range []Scale{b.LastScaleUp(), b.LastScaleDown()} {
+		if !s.Time.IsZero() {
+			records = append(records, record{Op: opScale, Scale: &s})
+		}
+	}
+	if d := b.ReplicaStartup(); d != 0 {
+		records = append(records, record{Op: opReplicaStartup, Duration: d})
+	}
+	// expired bans are dropped before their offences are written, so the offences are ended
+	var bans []record
+	b.impl.RangeBannedIPs(func(ip string, ban Ban) {
+		bans = append(bans, record{Op: opBan, IP: ip, Ban: &ban})
+	})
+	b.rangeOffences(func(ip string, offences []Offence) {
+		records = append(records, record{Op: opOffences, IP: ip, Offences: offences})
+	})
+	return append(records, bans...)
+}
+
+func (b *fileBase) compact() error {
+	tmp := b.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	e := json.NewEncoder(w)
+	for _, r := range b.snapshot() {
+		err = e.Encode(r)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, b.path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(b.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if b.file != nil {
+		b.file.Close()
+	}
+	b.file, b.written = file, 0
+	b.compacted, err = file.Seek(0, io.SeekEnd)
+	return err
+}
+
+// append writes a record to the log, and compacts it once it outgrew its snapshot.
+// The caller must hold the lock.
+func (b *fileBase) append(r record) {
+	if b.file == nil {
+		b.log.Printf("knowledge base is closed, dropping %q record", r.Op)
+		return
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		b.log.Println("could not encode record:", err)
+		return
+	}
+	n, err := b.file.Write(append(data, '\n'))
+	b.written += int64(n)
+	if err != nil {
+		b.log.Println("could not write record:", err)
+		return
+	}
+	if b.written >= max(compactSize, b.compacted) {
+		err = b.compact()
+		if err != nil {
+			b.log.Println("could not compact knowledge base file:", err)
+		}
+	}
+}
+
+// Close syncs and closes the log file. Changes after it are kept in memory only.
+func (b *fileBase) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Sync()
+	err = errors.Join(err, b.file.Close())
+	b.file = nil
+	return err
+}
+
+func (b *fileBase) SetLimit(limit int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.CurrentLimit() == limit && !b.HasPendingLimitChange() {
+		return
+	}
+	b.impl.SetLimit(limit)
+	b.append(record{Op: opLimit, Value: limit})
+	b.append(record{Op: opPendingLimitChange, Flag: false})
+}
+
+func (b *fileBase) SetReplicas(replicas int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.CurrentReplicas() == replicas && !b.HasPendingReplicaChange() {
+		return
+	}
+	b.impl.SetReplicas(replicas)
+	b.append(record{Op: opReplicas, Value: replicas})
+	b.append(record{Op: opPendingReplicaChange, Flag: false})
+}
+
+func (b *fileBase) SetPendingLimitChange(pending bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.HasPendingLimitChange() == pending {
+		return
+	}
+	b.impl.SetPendingLimitChange(pending)
+	b.append(record{Op: opPendingLimitChange, Flag: pending})
+}
+
+func (b *fileBase) SetPendingReplicaChange(pending bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.HasPendingReplicaChange() == pending {
+		return
+	}
+	b.impl.SetPendingReplicaChange(pending)
+	b.append(record{Op: opPendingReplicaChange, Flag: pending})
+}
+
+func (b *fileBase) SetMode(m Mode) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.impl.SetMode(m)
+	b.append(record{Op: opMode, Mode: &m})
+}
+
+func (b *fileBase) RecordScale(s Scale) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if s.From == s.To {
+		return
+	}
+	if s.Time.IsZero() {
+		s.Time = b.clock.Now()
+	}
+	b.impl.RecordScale(s)
+	b.append(record{Op: opScale, Scale: &s})
+}
+
+func (b *fileBase) RecordReplicaStartup(d time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.impl.RecordReplicaStartup(d)
+	b.append(record{Op: opReplicaStartup, Duration: b.ReplicaStartup()})
+}
+
+func (b *fileBase) RangeBannedIPs(f func(string, Ban)) {
+	// f is called without the lock, as it may change the bans
+	b.rangeBans(f, func(ip string, ban Ban) {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		// the address was banned again after the ban expired, and the new ban is already appended
+		if _, ok := b.bannedIPs.Load(ip); ok {
+			return
+		}
+		b.append(record{Op: opUnban, IP: ip, Time: &ban.Expiry})
+	})
+}
+
+func (b *fileBase) BanIP(ip string, ban Ban) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ban = b.withSince(ip, ban)
+	if b.ban(ip, ban) {
+		b.append(record{Op: opBan, IP: ip, Ban: &ban})
+	}
+}
+
+func (b *fileBase) UnbanIP(ip string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	t := b.clock.Now()
+	if b.unban(ip, t) {
+		b.append(record{Op: opUnban, IP: ip, Time: &t})
+	}
+}
@@ -0,0 +1,182 @@
+package knowledge
+
+import (
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fileState is the state of a file base that is persisted.
+type fileState struct {
+	limit, replicas                    int
+	pendingLimitChange, pendingReplica bool
+	mode                               Mode
+	bans                               map[string]Ban
+	offences                           map[string][]Offence
+	lastScaleUp, lastScaleDown         Scale
+	replicaStartup                     time.Duration
+}
+
+func stateOf(b *fileBase) fileState {
+	s := fileState{
+		limit:              b.CurrentLimit(),
+		replicas:           b.CurrentReplicas(),
+		pendingLimitChange: b.HasPendingLimitChange(),
+		pendingReplica:     b.HasPendingReplicaChange(),
+		mode:               b.Mode(),
+		bans:               make(map[string]Ban),
+		offences:           make(map[string][]Offence),
+		lastScaleUp:        b.LastScaleUp(),
+		lastScaleDown:      b.LastScaleDown(),
+		replicaStartup:     b.ReplicaStartup(),
+	}
+	b.impl.RangeBannedIPs(func(ip string, ban Ban) {
+		s.bans[ip] = ban
+	})
+	b.rangeOffences(func(ip string, offences []Offence) {
+		s.offences[ip] = offences
+	})
+	return s
+}
+
+func openTestFileBase(t *testing.T, path string, clock utils.Clock) *fileBase {
+	t.Helper()
+	b, err := NewFileBase(path, 10, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.(*fileBase)
+}
+
+// fillTestFileBase makes a change of every kind, and ends two minutes after testStart.
+func fillTestFileBase(b *fileBase, clock *utils.VirtualClock) {
+	b.SetLimit(30)
+	b.SetReplicas(3)
+	b.SetPendingLimitChange(true)
+	b.SetPendingReplicaChange(true)
+	b.SetMode(Mode{Kind: ModeOverride, Limit: 40, Replicas: 2, Until: testStart.Add(time.Hour)})
+	b.RecordScale(Scale{From: 1, To: 3})
+	b.RecordReplicaStartup(20 * time.Second)
+
+	b.BanIP("1.1.1.1", Ban{Expiry: testStart.Add(10 * time.Minute), Reason: "limited", Source: BanSourceAuto})
+	b.BanIP("2.2.2.0/24", Ban{Source: BanSourceManual})
+	b.BanIP("3.3.3.3", Ban{Expiry: testStart.Add(time.Minute), Source: BanSourceAuto})
+	b.BanIP("4.4.4.4", Ban{Source: BanSourceManual})
+
+	clock.Advance(2 * time.Minute)
+	b.UnbanIP("4.4.4.4")
+	b.RecordScale(Scale{From: 3, To: 2})
+	// the ban of 3.3.3.3 expired, ranging over the bans removes it
+	b.RangeBannedIPs(func(string, Ban) {})
+}
+
+func TestFileBaseRestoresState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "knowledge.log")
+	clock := utils.NewVirtualClock(testStart)
+	b := openTestFileBase(t, path, clock)
+	fillTestFileBase(b, clock)
+	want := stateOf(b)
+	err := b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log, _ := os.ReadFile(path)
+	if !strings.Contains(string(log), `{"op":"unban","ip":"3.3.3.3","time":"2024-01-01T00:01:00Z"}`) {
+		t.Errorf("the expired ban was not appended as an unban:\n%s", log)
+	}
+
+	restored := openTestFileBase(t, path, clock)
+	got := stateOf(restored)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restored state:\n%+v\nwant\n%+v", got, want)
+	}
+
+	if len(got.bans) != 2 || got.bans["1.1.1.1"].Expiry != testStart.Add(10*time.Minute) || !got.bans["2.2.2.0/24"].Permanent() {
+		t.Errorf("restored bans = %+v", got.bans)
+	}
+	wantOffences := map[string][]Offence{
+		"1.1.1.1":    {{BannedAt: testStart}},
+		"2.2.2.0/24": {{BannedAt: testStart}},
+		"3.3.3.3":    {{BannedAt: testStart, UnbannedAt: testStart.Add(time.Minute)}},
+		"4.4.4.4":    {{BannedAt: testStart, UnbannedAt: testStart.Add(2 * time.Minute)}},
+	}
+	if !reflect.DeepEqual(got.offences, wantOffences) {
+		t.Errorf("restored offences = %+v", got.offences)
+	}
+	if got.mode.Kind != ModeOverride || !got.pendingLimitChange || got.lastScaleDown.To != 2 {
+		t.Errorf("restored state = %+v", got)
+	}
+}
+
+func TestFileBaseSkipsTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "knowledge.log")
+	clock := utils.NewVirtualClock(testStart)
+	b := openTestFileBase(t, path, clock)
+	fillTestFileBase(b, clock)
+	want := stateOf(b)
+	err := b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash while a record was written
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"op":"limit","val`)
+	_ = f.Close()
+
+	restored := openTestFileBase(t, path, clock)
+	if got := stateOf(restored); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored state:\n%+v\nwant\n%+v", got, want)
+	}
+	restored.SetLimit(35)
+	_ = restored.Close()
+	if got := openTestFileBase(t, path, clock).CurrentLimit(); got != 35 {
+		t.Errorf("limit after the truncated record = %d, want 35", got)
+	}
+}
+
+func TestFileBaseCompactionKeepsState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "knowledge.log")
+	clock := utils.NewVirtualClock(testStart)
+	b := openTestFileBase(t, path, clock)
+	fillTestFileBase(b, clock)
+	want := stateOf(b)
+
+	// the limit records outgrow compactSize, so the log is compacted while they are appended
+	for limit, compacted := 0, false; !compacted; limit++ {
+		written := b.written
+		b.SetLimit(limit%2 + 100)
+		compacted = b.written < written
+	}
+	b.SetLimit(30)
+	b.SetPendingLimitChange(true)
+	if got := stateOf(b); !reflect.DeepEqual(got, want) {
+		t.Errorf("state after compaction:\n%+v\nwant\n%+v", got, want)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > compactSize+b.compacted {
+		t.Errorf("log was not compacted, its size is %d", info.Size())
+	}
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := openTestFileBase(t, path, clock)
+	if got := stateOf(restored); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored state after compaction:\n%+v\nwant\n%+v", got, want)
+	}
+}
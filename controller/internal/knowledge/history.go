@@ -0,0 +1,100 @@
+package knowledge
+
+import (
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"math"
+	"time"
+)
+
+// Adaptation is a set of changes that the plan module has executed.
+// Zero Limit or Replicas means they were left unchanged. A zero Time is set when recorded.
+type Adaptation struct {
+	Time     time.Time
+	Limit    int
+	Replicas int
+	Banned   []string
+	Unbanned []string
+	// DryRun is set when the changes were only recorded by the execute module.
+	DryRun bool
+}
+
+type Aggregate struct {
+	Count int
+	Mean  float64
+	Min   float64
+	Max   float64
+}
+
+func (a *Aggregate) add(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	if a.Count == 0 {
+		a.Min, a.Max = v, v
+	}
+	a.Mean += (v - a.Mean) / float64(a.Count+1)
+	a.Min = min(a.Min, v)
+	a.Max = max(a.Max, v)
+	a.Count++
+}
+
+// ReportAggregate summarizes the reports of a time window.
+type ReportAggregate struct {
+	From               time.Time
+	To                 time.Time
+	Reports            int
+	CpuUtilization     Aggregate
+	TotalRate          Aggregate
+	NonLimitedRate     Aggregate
+	GoodLatencyPercent Aggregate
+}
+
+func aggregateReports(reports []monitor.Report) ReportAggregate {
+	var result ReportAggregate
+	for _, r := range reports {
+		if result.Reports == 0 || r.Time.Before(result.From) {
+			result.From = r.Time
+		}
+		if r.Time.After(result.To) {
+			result.To = r.Time
+		}
+		result.Reports++
+		result.CpuUtilization.add(r.AverageCpuUtilization)
+		result.TotalRate.add(r.Requests.TotalRate)
+		result.NonLimitedRate.add(r.Requests.NonLimitedRate)
+		result.GoodLatencyPercent.add(r.Requests.GoodLatencyPercent)
+	}
+	return result
+}
+
+// ring is a fixed size buffer that overwrites its oldest items.
+type ring[T any] struct {
+	items []T
+	next  int
+	size  int
+}
+
+func newRing[T any](capacity int) ring[T] {
+	return ring[T]{items: make([]T, max(capacity, 0))}
+}
+
+func (r *ring[T]) push(item T) {
+	if len(r.items) == 0 {
+		return
+	}
+	r.items[r.next] = item
+	r.next = (r.next + 1) % len(r.items)
+	r.size = min(r.size+1, len(r.items))
+}
+
+// last returns the newest n items from oldest to newest, or all items if n is negative.
+func (r *ring[T]) last(n int) []T {
+	if n < 0 || n > r.size {
+		n = r.size
+	}
+	result := make([]T, 0, n)
+	for idx := n; idx > 0; idx-- {
+		result = append(result, r.items[(r.next-idx+len(r.items))%len(r.items)])
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package knowledge
+
+import (
+	"fmt"
+	"time"
+)
+
+type ModeKind string
+
+const (
+	// ModeAuto is the default mode, in which the analyzer's actions are executed.
+	ModeAuto ModeKind = "auto"
+	// ModePaused keeps monitoring and analyzing, but drops the analyzer's actions.
+	ModePaused ModeKind = "paused"
+	// ModeOverride pins the limit and replicas to operator values, while bans are still automatic.
+	ModeOverride ModeKind = "override"
+)
+
+// Mode is the operating mode of the control loop. Manual changes are executed in every mode.
+type Mode struct {
+	Kind ModeKind `json:"kind"`
+	// Limit and Replicas are the pinned values of ModeOverride, zero means not pinned.
+	Limit    int `json:"limit,omitempty"`
+	Replicas int `json:"replicas,omitempty"`
+	// Until is when the mode ends and the loop returns to ModeAuto, zero means never.
+	Until time.Time `json:"until,omitempty"`
+}
+
+func (m Mode) expired(now time.Time) bool {
+	return !m.Until.IsZero() && !now.Before(m.Until)
+}
+
+func (m Mode) String() string {
+	s := string(m.Kind)
+	if m.Kind == ModeOverride {
+		s += fmt.Sprintf(" (limit=%d, replicas=%d)", m.Limit, m.Replicas)
+	}
+	if !m.Until.IsZero() {
+		s += " until " + m.Until.Format(time.DateTime)
+	}
+	return s
+}
@@ -0,0 +1,58 @@
+package knowledge
+
+import (
+	"slices"
+	"time"
+)
+
+// Offence is a ban of an address or prefix. UnbannedAt is zero while the ban is active.
+type Offence struct {
+	BannedAt   time.Time `json:"banned_at"`
+	UnbannedAt time.Time `json:"unbanned_at,omitempty"`
+}
+
+// maxOffences is the number of offences kept for each address.
+const maxOffences = 16
+
+func (i *impl) Offences(ip string) []Offence {
+	i.offencesLock.RLock()
+	defer i.offencesLock.RUnlock()
+	return slices.Clone(i.offences[ip])
+}
+
+func (i *impl) rangeOffences(f func(string, []Offence)) {
+	i.offencesLock.RLock()
+	defer i.offencesLock.RUnlock()
+	for ip, o := range i.offences {
+		f(ip, slices.Clone(o))
+	}
+}
+
+func (i *impl) setOffences(ip string, offences []Offence) {
+	i.offencesLock.Lock()
+	defer i.offencesLock.Unlock()
+	i.offences[ip] = offences
+}
+
+func (i *impl) startOffence(ip string, t time.Time) {
+	i.offencesLock.Lock()
+	defer i.offencesLock.Unlock()
+	offences := i.offences[ip]
+	if n := len(offences); n > 0 && offences[n-1].BannedAt.Equal(t) {
+		return
+	}
+	offences = append(offences, Offence{BannedAt: t})
+	if len(offences) > maxOffences {
+		offences = offences[len(offences)-maxOffences:]
+	}
+	i.offences[ip] = offences
+}
+
+func (i *impl) endOffence(ip string, t time.Time) {
+	i.offencesLock.Lock()
+	defer i.offencesLock.Unlock()
+	offences := i.offences[ip]
+	if n := len(offences); n > 0 && offences[n-1].UnbannedAt.IsZero() {
+		offences[n-1].UnbannedAt = t
+	}
+}
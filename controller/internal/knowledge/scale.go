@@ -0,0 +1,73 @@
+package knowledge
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Scale is an executed change of the service's replicas.
+type Scale struct {
+	Time time.Time `json:"time"`
+	From int       `json:"from"`
+	To   int       `json:"to"`
+}
+
+func (s Scale) Up() bool {
+	return s.To > s.From
+}
+
+func (i *impl) LastScale() Scale {
+	up, down := i.LastScaleUp(), i.LastScaleDown()
+	if up.Time.After(down.Time) {
+		return up
+	}
+	return down
+}
+
+func (i *impl) LastScaleUp() Scale {
+	return loadScale(&i.lastScaleUp)
+}
+
+func (i *impl) LastScaleDown() Scale {
+	return loadScale(&i.lastScaleDown)
+}
+
+func loadScale(p *atomic.Pointer[Scale]) Scale {
+	s := p.Load()
+	if s == nil {
+		return Scale{}
+	}
+	return *s
+}
+
+// RecordScale records a scale in the direction it changes the replicas, scales that do not change them are ignored.
+func (i *impl) RecordScale(s Scale) {
+	if s.Time.IsZero() {
+		s.Time = i.clock.Now()
+	}
+	if s.Up() {
+		i.lastScaleUp.Store(&s)
+	} else if s.To < s.From {
+		i.lastScaleDown.Store(&s)
+	}
+}
+
+func (i *impl) ReplicaStartup() time.Duration {
+	return time.Duration(i.replicaStartup.Load())
+}
+
+func (i *impl) RecordReplicaStartup(d time.Duration) {
+	i.setReplicaStartup(smoothStartup(i.ReplicaStartup(), d))
+}
+
+func (i *impl) setReplicaStartup(d time.Duration) {
+	i.replicaStartup.Store(int64(d))
+}
+
+// smoothStartup averages the observed startup times, weighting the newest one by half.
+func smoothStartup(current, observed time.Duration) time.Duration {
+	if current == 0 {
+		return observed
+	}
+	return (current + observed) / 2
+}
@@ -2,11 +2,13 @@ package internal
 
 import (
 	"context"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/admin"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/analyze"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/execute"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/plan"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
 	"log"
 	"os"
 	"os/signal"
@@ -16,30 +18,64 @@ import (
 func RunControlLoop(config *Config) {
 	log.Printf("Config: %+v\n", *config)
 
-	k := knowledge.NewInMemoryBase()
-	m := monitor.NewModule(config.Monitor, k)
-	a := analyze.NewModule(config.Analyze, k)
-	e := execute.NewModule(config.Execute, k)
+	k, err := knowledge.NewBase(config.Knowledge, utils.SystemClock)
+	if err != nil {
+		log.Fatalf("could not create knowledge base: %s", err)
+	}
+	m := monitor.NewModule(config.Monitor, monitor.NewPrometheusSource(config.Monitor))
+	a := analyze.NewModule(config.Analyze, k, utils.SystemClock)
+	o, err := execute.NewOrchestrator(config.Execute)
+	if err != nil {
+		log.Fatalf("could not create orchestrator: %s", err)
+	}
+	g, err := execute.NewGateway(config.Execute)
+	if err != nil {
+		log.Fatalf("could not create gateway: %s", err)
+	}
+	f, err := execute.NewFirewall(config.Execute)
+	if err != nil {
+		log.Fatalf("could not create firewall: %s", err)
+	}
+	e := execute.NewModule(config.Execute, k, o, g, f, utils.SystemClock)
 	p := plan.NewModule(config.Plan, k, e)
-	run(m, a, p, e)
-}
 
-func run(m monitor.Module, a analyze.Module, p plan.Module, e execute.Module) {
+	var ad admin.Module
+	if config.Admin.Enabled {
+		ad, err = admin.NewModule(config.Admin, k, utils.SystemClock)
+		if err != nil {
+			log.Fatalf("could not create admin module: %s", err)
+		}
+	}
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
+	run(ctx, m, a, p, e, ad)
+
+	err = k.Close()
+	if err != nil {
+		log.Printf("could not close knowledge base: %s", err)
+	}
+}
 
+// run starts the modules, and stops them once ctx is done.
+func run(ctx context.Context, m monitor.Module, a analyze.Module, p plan.Module, e execute.Module, ad admin.Module) {
 	// start MAPE-K modules
 	reports := m.Start()
 	actions := a.Start(reports)
+	if ad != nil {
+		actions = utils.Merge(actions, ad.Start())
+	}
 	p.Start(actions)
 	e.Start()
 
-	// wait for termination signal
+	// wait for termination
 	<-ctx.Done()
 
 	// stop modules
 	m.Stop()
 	a.Stop()
+	if ad != nil {
+		ad.Stop()
+	}
 	p.Stop()
 	e.Stop()
 }
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/analyze"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/execute"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/monitor"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/plan"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingGateway is a push gateway that keeps the last configuration it was given.
+type recordingGateway struct {
+	lock   sync.Mutex
+	limit  int
+	banned []string
+}
+
+func (g *recordingGateway) Apply(_ context.Context, limit int, banned []string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.limit, g.banned = limit, slices.Clone(banned)
+	return nil
+}
+
+func (g *recordingGateway) applied() (int, []string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.limit, g.banned
+}
+
+func TestControlLoop(t *testing.T) {
+	config := Default()
+	config.Monitor.ReportPeriod = 10 * time.Millisecond
+	config.Plan.MergeTimeout = 10 * time.Millisecond
+	config.Execute.ReconcilePeriod = 10 * time.Millisecond
+
+	source := monitor.NewMemorySource()
+	source.SetCpuUtilization(1.4)
+	source.SetRequests(monitor.Requests{TotalRate: 100, NonLimitedRate: 60, GoodLatencyPercent: 0.8})
+	source.SetIPStats(map[string]monitor.IPStats{
+		"10.0.0.1": {TotalRate: 5, LimitedRate: 0},
+		"10.0.0.2": {TotalRate: 40, LimitedRate: 30},
+	})
+	o := execute.NewMemoryOrchestrator(1)
+	g := &recordingGateway{}
+
+	k := knowledge.NewInMemoryBase(config.Knowledge.HistorySize, utils.SystemClock)
+	m := monitor.NewModule(config.Monitor, source)
+	a := analyze.NewModule(config.Analyze, k, utils.SystemClock)
+	e := execute.NewModule(config.Execute, k, o, g, nil, utils.SystemClock)
+	p := plan.NewModule(config.Plan, k, e)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		run(ctx, m, a, p, e, nil)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		replicas, _ := o.Replicas(context.Background())
+		_, banned := g.applied()
+		if replicas > 1 && slices.Contains(banned, "10.0.0.2") {
+			break
+		}
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatalf("control loop did not adapt, replicas = %d, banned = %v", replicas, banned)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("control loop did not stop")
+	}
+
+	if replicas, _ := o.Replicas(context.Background()); k.CurrentReplicas() != replicas {
+		t.Errorf("knowledge base has %d replicas, orchestrator has %d", k.CurrentReplicas(), replicas)
+	}
+	limit, banned := g.applied()
+	if k.CurrentLimit() != limit {
+		t.Errorf("knowledge base has limit %d, gateway has %d", k.CurrentLimit(), limit)
+	}
+	if slices.Contains(banned, "10.0.0.1") {
+		t.Errorf("well-behaved address was banned: %v", banned)
+	}
+	if len(k.LastDecisions(1)) != 1 {
+		t.Errorf("no analyzer decision was recorded")
+	}
+}
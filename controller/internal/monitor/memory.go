@@ -0,0 +1,75 @@
+package monitor
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+)
+
+// MemorySource is a MetricsSource whose measurements are set by hand.
+// It lets the control loop run without a metrics server.
+type MemorySource struct {
+	lock           sync.Mutex
+	requests       Requests
+	cpuUtilization float64
+	running        int
+	ipStats        map[string]IPStats
+}
+
+func NewMemorySource() *MemorySource {
+	return &MemorySource{
+		requests:       Requests{GoodLatencyPercent: 1},
+		cpuUtilization: 1,
+		running:        1,
+		ipStats:        make(map[string]IPStats),
+	}
+}
+
+func (m *MemorySource) SetRequests(requests Requests) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.requests = requests
+}
+
+func (m *MemorySource) SetCpuUtilization(utilization float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cpuUtilization = utilization
+}
+
+func (m *MemorySource) SetRunningReplicas(replicas int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.running = replicas
+}
+
+func (m *MemorySource) SetIPStats(ipStats map[string]IPStats) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.ipStats = maps.Clone(ipStats)
+}
+
+func (m *MemorySource) Requests(context.Context, time.Time) (Requests, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.requests, nil
+}
+
+func (m *MemorySource) CpuUtilization(context.Context, time.Time) (float64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.cpuUtilization, nil
+}
+
+func (m *MemorySource) IPStats(context.Context, time.Time) (map[string]IPStats, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return maps.Clone(m.ipStats), nil
+}
+
+func (m *MemorySource) RunningReplicas(context.Context, time.Time) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.running, nil
+}
@@ -3,19 +3,16 @@ package monitor
 import (
 	"context"
 	"fmt"
-	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
 	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
-	"github.com/prometheus/client_golang/api"
-	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
-	"github.com/prometheus/common/model"
 	"log"
-	"math"
 	"sync"
 	"time"
 )
 
 type Module interface {
 	Start() <-chan Report
+	// Collect builds a single report for the given time.
+	Collect(ctx context.Context, now time.Time) (Report, error)
 	Stop()
 }
 
@@ -27,18 +24,20 @@ type Requests struct {
 }
 
 type Report struct {
+	Time                  time.Time
 	AverageCpuUtilization float64
+	RunningReplicas       int
 	Requests              Requests
 	PotentialAttackerIPs  map[string]float64
+	IPStats               map[string]IPStats
 }
 
 type impl struct {
-	cfg           Config
-	knowledgeBase knowledge.Base
-	stop          context.CancelFunc
-	wg            *sync.WaitGroup
-	metricsClient v1.API
-	log           *log.Logger
+	cfg    Config
+	stop   context.CancelFunc
+	wg     *sync.WaitGroup
+	source MetricsSource
+	log    *log.Logger
 }
 
 type Config struct {
@@ -49,19 +48,11 @@ type Config struct {
 	AttackerPercentThreshold float64       `config:"attacker_percent_threshold"`
 }
 
-func NewModule(cfg Config, k knowledge.Base) Module {
-	client, err := api.NewClient(api.Config{
-		Address: cfg.MetricsAddress,
-	})
-	if err != nil {
-		panic(err)
-	}
-
+func NewModule(cfg Config, source MetricsSource) Module {
 	return &impl{
-		cfg:           cfg,
-		knowledgeBase: k,
-		metricsClient: v1.NewAPI(client),
-		log:           utils.GetLogger("monitor"),
+		cfg:    cfg,
+		source: source,
+		log:    utils.GetLogger("monitor"),
 	}
 }
 
@@ -93,126 +84,54 @@ func (i *impl) monitor(ctx context.Context, reports chan<- Report) {
 		case <-ctx.Done():
 			return
 		case t := <-ticker.C:
-			requests, err := i.getRequestsReport(t)
-			if err != nil {
-				i.log.Println("failed to get requests report:", err)
-				continue
-			}
-			i.log.Printf("requests: %+v\n", requests)
-			cpu, err := i.getAverageCpuUtil(t)
+			r, err := i.Collect(ctx, t)
 			if err != nil {
-				i.log.Println("failed to get cpu report:", err)
+				i.log.Println(err)
 				continue
 			}
-			i.log.Printf("cpu util: %+v\n", cpu)
-			attackerIPs, err := i.getPotentialAttackerIPs(t)
-			if err != nil {
-				i.log.Println("failed to get potential attacker ip report:", err)
-				continue
-			}
-			i.log.Printf("attackers: %+v\n", attackerIPs)
-
-			reports <- Report{
-				AverageCpuUtilization: cpu,
-				Requests:              requests,
-				PotentialAttackerIPs:  attackerIPs,
-			}
+			reports <- r
 		}
 	}
 }
 
-func (i *impl) getAverageCpuUtil(now time.Time) (float64, error) {
-	query := fmt.Sprintf(`avg(rate(process_cpu_seconds_total{job="file-server"}[%s]))`, i.cfg.MetricsPeriod)
-	value, err := singleValue(i.queryPrometheus(query, now))
-	if value == 0 || value == math.NaN() {
-		value = i.cfg.CpuQuota
-	}
-	return value / i.cfg.CpuQuota, err
-}
-
-func (i *impl) getRequestsReport(now time.Time) (Requests, error) {
-	var err error
-	result := Requests{}
-
-	query1 := fmt.Sprintf(`sum(rate(traefik_entrypoint_requests_total{code!="403"}[%s]))`, i.cfg.MetricsPeriod)
-	result.TotalRate, err = singleValue(i.queryPrometheus(query1, now))
+func (i *impl) Collect(ctx context.Context, now time.Time) (Report, error) {
+	requests, err := i.source.Requests(ctx, now)
 	if err != nil {
-		return result, err
+		return Report{}, fmt.Errorf("failed to get requests report: %w", err)
 	}
-
-	query2 := fmt.Sprintf(`sum(rate(traefik_entrypoint_requests_total{code!="403", code!="429"}[%s]))`, i.cfg.MetricsPeriod)
-	result.NonLimitedRate, err = singleValue(i.queryPrometheus(query2, now))
+	i.log.Printf("requests: %+v\n", requests)
+	cpu, err := i.source.CpuUtilization(ctx, now)
 	if err != nil {
-		return result, err
-	}
-
-	query3 := fmt.Sprintf(`sum(rate(traefik_entrypoint_request_duration_seconds_bucket{code!="403", code!="429", le="1.2"}[%s])) / sum(rate(traefik_entrypoint_request_duration_seconds_count{code!="403", code!="429"}[%s]))`, i.cfg.MetricsPeriod, i.cfg.MetricsPeriod)
-	result.GoodLatencyPercent, err = singleValue(i.queryPrometheus(query3, now))
-	if result.GoodLatencyPercent == 0 || math.IsNaN(result.GoodLatencyPercent) {
-		result.GoodLatencyPercent = 1
+		return Report{}, fmt.Errorf("failed to get cpu report: %w", err)
 	}
+	i.log.Printf("cpu util: %+v\n", cpu)
+	running, err := i.source.RunningReplicas(ctx, now)
 	if err != nil {
-		return result, err
+		return Report{}, fmt.Errorf("failed to get running replicas: %w", err)
 	}
-
-	query4 := fmt.Sprintf(`stddev(rate(traefik_entrypoint_requests_total{code="429"}[%s]))`, i.cfg.MetricsPeriod)
-	result.LimitedRatesStdDev, err = singleValue(i.queryPrometheus(query4, now))
+	ipStats, err := i.source.IPStats(ctx, now)
 	if err != nil {
-		return result, err
-	}
-	return result, nil
+		return Report{}, fmt.Errorf("failed to get potential attacker ip report: %w", err)
+	}
+	attackerIPs := i.getPotentialAttackerIPs(ipStats)
+	i.log.Printf("attackers: %+v\n", attackerIPs)
+
+	return Report{
+		Time:                  now,
+		AverageCpuUtilization: cpu,
+		RunningReplicas:       running,
+		Requests:              requests,
+		PotentialAttackerIPs:  attackerIPs,
+		IPStats:               ipStats,
+	}, nil
 }
 
-func (i *impl) getPotentialAttackerIPs(now time.Time) (map[string]float64, error) {
-	query := fmt.Sprintf(`sum(rate(traefik_entrypoint_requests_total{code="429"}[%s])) by (ip) / sum(rate(traefik_entrypoint_requests_total[%s])) by (ip) > %f`,
-		i.cfg.MetricsPeriod, i.cfg.MetricsPeriod, i.cfg.AttackerPercentThreshold)
-	return ipValues(i.queryPrometheus(query, now))
-}
-
-func (i *impl) queryPrometheus(query string, now time.Time) (model.Vector, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	result, warnings, err := i.metricsClient.Query(ctx, query, now)
-	if err != nil {
-		return nil, err
-	}
-	if len(warnings) > 0 {
-		i.log.Printf("Warnings: %v\n", warnings)
-	}
-
-	vector, ok := result.(model.Vector)
-	if !ok {
-		return vector, fmt.Errorf("unexpected result format, expected vector")
-	}
-
-	return vector, nil
-}
-
-func singleValue(vector model.Vector, err error) (float64, error) {
-	if err != nil {
-		return 0, err
-	}
-	if len(vector) > 1 {
-		return 0, fmt.Errorf("unexpected result format, len vector not 1 or 0: %v", len(vector))
-	}
-	if len(vector) == 0 {
-		return 0, nil
-	}
-	return float64(vector[0].Value), nil
-}
-
-func ipValues(vector model.Vector, err error) (map[string]float64, error) {
-	if err != nil {
-		return nil, err
-	}
+func (i *impl) getPotentialAttackerIPs(stats map[string]IPStats) map[string]float64 {
 	result := make(map[string]float64)
-	for _, v := range vector {
-		ip, ok := v.Metric["ip"]
-		if !ok {
-			continue
+	for ip, s := range stats {
+		if p := s.LimitedPercent(); p > i.cfg.AttackerPercentThreshold {
+			result[ip] = p
 		}
-		result[string(ip)] = float64(v.Value)
 	}
-	return result, nil
+	return result
 }
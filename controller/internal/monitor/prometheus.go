@@ -0,0 +1,202 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/utils"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"log"
+	"math"
+	"time"
+)
+
+type prometheusSource struct {
+	cfg           Config
+	metricsClient v1.API
+	log           *log.Logger
+}
+
+func NewPrometheusSource(cfg Config) MetricsSource {
+	client, err := api.NewClient(api.Config{
+		Address: cfg.MetricsAddress,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return &prometheusSource{
+		cfg:           cfg,
+		metricsClient: v1.NewAPI(client),
+		log:           utils.GetLogger("monitor"),
+	}
+}
+
+func (p *prometheusSource) CpuUtilization(ctx context.Context, now time.Time) (float64, error) {
+	query := fmt.Sprintf(`avg(rate(process_cpu_seconds_total{job="file-server"}[%s]))`, p.cfg.MetricsPeriod)
+	value, err := singleValue(p.query(ctx, query, now))
+	if value == 0 || math.IsNaN(value) {
+		value = p.cfg.CpuQuota
+	}
+	return value / p.cfg.CpuQuota, err
+}
+
+func (p *prometheusSource) RunningReplicas(ctx context.Context, now time.Time) (int, error) {
+	value, err := singleValue(p.query(ctx, `count(up{job="file-server"} == 1)`, now))
+	return int(value), err
+}
+
+func (p *prometheusSource) Requests(ctx context.Context, now time.Time) (Requests, error) {
+	var err error
+	result := Requests{}
+
+	query1 := fmt.Sprintf(`sum(rate(traefik_entrypoint_requests_total{code!="403"}[%s]))`, p.cfg.MetricsPeriod)
+	result.TotalRate, err = singleValue(p.query(ctx, query1, now))
+	if err != nil {
+		return result, err
+	}
+
+	query2 := fmt.Sprintf(`sum(rate(traefik_entrypoint_requests_total{code!="403", code!="429"}[%s]))`, p.cfg.MetricsPeriod)
+	result.NonLimitedRate, err = singleValue(p.query(ctx, query2, now))
+	if err != nil {
+		return result, err
+	}
+
+	query3 := fmt.Sprintf(`sum(rate(traefik_entrypoint_request_duration_seconds_bucket{code!="403", code!="429", le="1.2"}[%s])) / sum(rate(traefik_entrypoint_request_duration_seconds_count{code!="403", code!="429"}[%s]))`, p.cfg.MetricsPeriod, p.cfg.MetricsPeriod)
+	result.GoodLatencyPercent, err = singleValue(p.query(ctx, query3, now))
+	if result.GoodLatencyPercent == 0 || math.IsNaN(result.GoodLatencyPercent) {
+		result.GoodLatencyPercent = 1
+	}
+	if err != nil {
+		return result, err
+	}
+
+	query4 := fmt.Sprintf(`stddev(rate(traefik_entrypoint_requests_total{code="429"}[%s]))`, p.cfg.MetricsPeriod)
+	result.LimitedRatesStdDev, err = singleValue(p.query(ctx, query4, now))
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func (p *prometheusSource) IPStats(ctx context.Context, now time.Time) (map[string]IPStats, error) {
+	query1 := fmt.Sprintf(`sum(rate(traefik_entrypoint_requests_total[%s])) by (ip)`, p.cfg.MetricsPeriod)
+	totals, err := ipValues(p.query(ctx, query1, now))
+	if err != nil {
+		return nil, err
+	}
+
+	query2 := fmt.Sprintf(`sum(rate(traefik_entrypoint_requests_total{code="429"}[%s])) by (ip)`, p.cfg.MetricsPeriod)
+	limited, err := ipValues(p.query(ctx, query2, now))
+	if err != nil {
+		return nil, err
+	}
+
+	query3 := fmt.Sprintf(`sum(rate(traefik_entrypoint_requests_total{code="404"}[%s])) by (ip)`, p.cfg.MetricsPeriod)
+	notFound, err := ipValues(p.query(ctx, query3, now))
+	if err != nil {
+		return nil, err
+	}
+
+	query4 := fmt.Sprintf(`sum(rate(traefik_entrypoint_request_duration_seconds_sum[%s])) by (ip)`, p.cfg.MetricsPeriod)
+	latency, err := ipValues(p.query(ctx, query4, now))
+	if err != nil {
+		return nil, err
+	}
+
+	query5 := fmt.Sprintf(`sum(rate(http_request_path_buckets_total{job="file-server"}[%s])) by (ip, bucket)`, p.cfg.MetricsPeriod)
+	entropy, err := ipEntropies(p.query(ctx, query5, now))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]IPStats, len(totals))
+	for ip, total := range totals {
+		result[ip] = IPStats{
+			TotalRate:      total,
+			LimitedRate:    limited[ip],
+			NotFoundRate:   notFound[ip],
+			LatencySeconds: latency[ip],
+			PathEntropy:    entropy[ip],
+		}
+	}
+	return result, nil
+}
+
+func (p *prometheusSource) query(ctx context.Context, query string, now time.Time) (model.Vector, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, warnings, err := p.metricsClient.Query(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(warnings) > 0 {
+		p.log.Printf("Warnings: %v\n", warnings)
+	}
+
+	vector, ok := result.(model.Vector)
+	if !ok {
+		return vector, fmt.Errorf("unexpected result format, expected vector")
+	}
+
+	return vector, nil
+}
+
+func singleValue(vector model.Vector, err error) (float64, error) {
+	if err != nil {
+		return 0, err
+	}
+	if len(vector) > 1 {
+		return 0, fmt.Errorf("unexpected result format, len vector not 1 or 0: %v", len(vector))
+	}
+	if len(vector) == 0 {
+		return 0, nil
+	}
+	return float64(vector[0].Value), nil
+}
+
+func ipValues(vector model.Vector, err error) (map[string]float64, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]float64)
+	for _, v := range vector {
+		ip, ok := v.Metric["ip"]
+		if !ok {
+			continue
+		}
+		result[string(ip)] = float64(v.Value)
+	}
+	return result, nil
+}
+
+// ipEntropies returns the shannon entropy of the rates of each ip across the values of the other labels.
+func ipEntropies(vector model.Vector, err error) (map[string]float64, error) {
+	if err != nil {
+		return nil, err
+	}
+	rates := make(map[string][]float64)
+	for _, v := range vector {
+		ip, ok := v.Metric["ip"]
+		if !ok || math.IsNaN(float64(v.Value)) || v.Value <= 0 {
+			continue
+		}
+		rates[string(ip)] = append(rates[string(ip)], float64(v.Value))
+	}
+	result := make(map[string]float64, len(rates))
+	for ip, values := range rates {
+		var total float64
+		for _, v := range values {
+			total += v
+		}
+		var h float64
+		for _, v := range values {
+			q := v / total
+			h -= q * math.Log2(q)
+		}
+		result[ip] = h
+	}
+	return result, nil
+}
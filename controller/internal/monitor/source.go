@@ -0,0 +1,40 @@
+package monitor
+
+import (
+	"context"
+	"time"
+)
+
+// MetricsSource provides the raw measurements that the monitor module turns into reports.
+type MetricsSource interface {
+	Requests(ctx context.Context, now time.Time) (Requests, error)
+	// CpuUtilization returns the average cpu usage of the service relative to its quota.
+	CpuUtilization(ctx context.Context, now time.Time) (float64, error)
+	IPStats(ctx context.Context, now time.Time) (map[string]IPStats, error)
+	// RunningReplicas returns the number of replicas of the service that are up.
+	RunningReplicas(ctx context.Context, now time.Time) (int, error)
+}
+
+type IPStats struct {
+	TotalRate    float64
+	LimitedRate  float64
+	NotFoundRate float64
+	// LatencySeconds is the time spent serving the requests of the address per second.
+	LatencySeconds float64
+	// PathEntropy is the shannon entropy of the requested paths in bits.
+	PathEntropy float64
+}
+
+func (s IPStats) LimitedPercent() float64 {
+	if s.TotalRate == 0 {
+		return 0
+	}
+	return s.LimitedRate / s.TotalRate
+}
+
+func (s IPStats) NotFoundPercent() float64 {
+	if s.TotalRate == 0 {
+		return 0
+	}
+	return s.NotFoundRate / s.TotalRate
+}
@@ -1,6 +1,7 @@
 package plan
 
 import (
+	"github.com/MeysamBavi/adaptive-anti-dos/controller/internal/knowledge"
 	"net"
 	"sync"
 )
@@ -23,29 +24,52 @@ func AdaptReplicas(newReplicas int) AdaptationAction {
 	}
 }
 
-func BanIP(ip string) AdaptationAction {
+// BanIP bans a single address or a CIDR prefix.
+func BanIP(ip string, ban knowledge.Ban) AdaptationAction {
 	return func(c *changes) {
 		c.lock.Lock()
 		defer c.lock.Unlock()
-		if v := net.ParseIP(ip); v != nil {
-			c.BanOrUnban[v.String()] = true
+		if v, ok := NormalizeIP(ip); ok {
+			c.BanOrUnban[v] = true
+			c.Bans[v] = ban
 		}
 	}
 }
 
+// UnbanIP unbans a single address or a CIDR prefix.
 func UnbanIP(ip string) AdaptationAction {
 	return func(c *changes) {
 		c.lock.Lock()
 		defer c.lock.Unlock()
-		if v := net.ParseIP(ip); v != nil {
-			c.BanOrUnban[v.String()] = false
+		if v, ok := NormalizeIP(ip); ok {
+			c.BanOrUnban[v] = false
+			delete(c.Bans, v)
 		}
 	}
 }
 
+// NormalizeIP returns the canonical form of an address or a CIDR prefix.
+func NormalizeIP(ip string) (string, bool) {
+	if v := net.ParseIP(ip); v != nil {
+		return v.String(), true
+	}
+	if _, network, err := net.ParseCIDR(ip); err == nil {
+		return network.String(), true
+	}
+	return "", false
+}
+
 type changes struct {
 	lock       sync.Mutex
 	Limit      int
 	Replicas   int
 	BanOrUnban map[string]bool
+	Bans       map[string]knowledge.Ban
+}
+
+func newChanges() *changes {
+	return &changes{
+		BanOrUnban: make(map[string]bool),
+		Bans:       make(map[string]knowledge.Ban),
+	}
 }
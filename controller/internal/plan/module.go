@@ -13,6 +13,8 @@ import (
 
 type Module interface {
 	Start(actions <-chan AdaptationAction)
+	// Execute merges the given actions and executes them right away.
+	Execute(actions ...AdaptationAction) error
 	Stop()
 }
 
@@ -52,7 +54,7 @@ func (i *impl) Stop() {
 func (i *impl) planAndExecute(actions <-chan AdaptationAction) {
 	defer i.wg.Done()
 	ticker := time.NewTicker(i.cfg.MergeTimeout)
-	ch := &changes{BanOrUnban: make(map[string]bool)}
+	ch := newChanges()
 	mergedChanges := 0
 	for {
 		select {
@@ -72,11 +74,22 @@ func (i *impl) planAndExecute(actions <-chan AdaptationAction) {
 				i.log.Printf("Error executing changes: %s", err)
 			}
 			mergedChanges = 0
-			ch = &changes{BanOrUnban: make(map[string]bool)}
+			ch = newChanges()
 		}
 	}
 }
 
+func (i *impl) Execute(actions ...AdaptationAction) error {
+	if len(actions) == 0 {
+		return nil
+	}
+	ch := newChanges()
+	for _, a := range actions {
+		a(ch)
+	}
+	return i.executeChanges(ch)
+}
+
 func (i *impl) executeChanges(ch *changes) error {
 	ch.lock.Lock()
 	defer ch.lock.Unlock()
@@ -85,21 +98,28 @@ func (i *impl) executeChanges(ch *changes) error {
 	defer cancel()
 
 	var err error
+	adaptation := knowledge.Adaptation{DryRun: i.executeModule.DryRun()}
 	for ip, ban := range ch.BanOrUnban {
 		if ban {
-			i.executeModule.BanIP(ip)
+			i.executeModule.BanIP(ip, ch.Bans[ip])
+			adaptation.Banned = append(adaptation.Banned, ip)
 		} else {
 			i.executeModule.UnbanIP(ip)
+			adaptation.Unbanned = append(adaptation.Unbanned, ip)
 		}
 	}
 	if ch.Replicas != 0 {
 		err = i.executeModule.ScaleService(ctx, ch.Replicas)
 		if err != nil {
 			err = fmt.Errorf("failed to execute scale change: %s", err)
+		} else {
+			adaptation.Replicas = ch.Replicas
 		}
 	}
 	if ch.Limit != 0 {
 		i.executeModule.SetRateLimit(ch.Limit)
+		adaptation.Limit = ch.Limit
 	}
+	i.knowledgeBase.RecordAdaptation(adaptation)
 	return err
 }
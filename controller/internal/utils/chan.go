@@ -0,0 +1,23 @@
+package utils
+
+import "sync"
+
+// Merge forwards the values of all channels to a single channel, which is closed after all of them are closed.
+func Merge[T any](channels ...<-chan T) <-chan T {
+	out := make(chan T)
+	wg := &sync.WaitGroup{}
+	wg.Add(len(channels))
+	for _, ch := range channels {
+		go func() {
+			defer wg.Done()
+			for v := range ch {
+				out <- v
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
@@ -1,10 +1,18 @@
 package utils
 
 import (
+	"io"
 	"log"
 	"os"
 )
 
+var output io.Writer = os.Stderr
+
 func GetLogger(prefix string) *log.Logger {
-	return log.New(os.Stderr, prefix, log.Ltime|log.Ldate)
+	return log.New(output, prefix, log.Ltime|log.Ldate)
+}
+
+// SetLogOutput changes the destination of loggers created afterward.
+func SetLogOutput(w io.Writer) {
+	output = w
 }
@@ -17,14 +17,30 @@ func FileServerHandler(w http.ResponseWriter, r *http.Request) {
 	w = wrw
 	fileName := strings.TrimPrefix(r.URL.Path, "/")
 
-	ip := r.RemoteAddr
-	if ips := strings.Split(ip, ":"); len(ips) > 1 {
-		ip = ips[0]
-	}
+	ip := clientIP(r)
 
 	defer func() {
-		ObserveRequestMetrics(start, wrw.Status(), ip)
+		ObserveRequestMetrics(start, wrw.Status(), ip, r.URL.Path)
 	}()
 
 	http.ServeFile(w, r, filepath.Join(filesDirectory, fileName))
 }
+
+// clientIP returns the address that the gateway rate limits and labels its metrics by. The gateway
+// takes the last X-Forwarded-For entry it received, and appends the address of its own client when
+// it forwards the request, so that entry is the one before the last. Without the header, it is the
+// remote address.
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		ips := strings.Split(strings.Join(forwarded, ","), ",")
+		if len(ips) > 1 {
+			return strings.TrimSpace(ips[len(ips)-2])
+		}
+		return strings.TrimSpace(ips[0])
+	}
+	ip := r.RemoteAddr
+	if ips := strings.Split(ip, ":"); len(ips) > 1 {
+		ip = ips[0]
+	}
+	return ip
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		forwarded []string
+		want      string
+	}{
+		{name: "no header", want: "172.18.0.5"},
+		{name: "appended by the gateway only", forwarded: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "sent by the client", forwarded: []string{"203.0.113.9, 198.51.100.7"}, want: "203.0.113.9"},
+		{name: "forged entries before it", forwarded: []string{"1.1.1.1, 203.0.113.9, 198.51.100.7"}, want: "203.0.113.9"},
+		{name: "repeated header", forwarded: []string{"203.0.113.9", "198.51.100.7"}, want: "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+			r.RemoteAddr = "172.18.0.5:41234"
+			for _, f := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileServerLabelsPathBucketsByClient(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/missing.txt", nil)
+	r.RemoteAddr = "172.18.0.5:41234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 172.18.0.1")
+	FileServerHandler(httptest.NewRecorder(), r)
+
+	bucket := pathBucket("/missing.txt")
+	if got := testutil.ToFloat64(requestPathBuckets.WithLabelValues("203.0.113.9", bucket)); got != 1 {
+		t.Errorf("path bucket counter of the client = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(requestPathBuckets.WithLabelValues("172.18.0.5", bucket)); got != 0 {
+		t.Errorf("path bucket counter of the gateway = %v, want 0", got)
+	}
+}
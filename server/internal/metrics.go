@@ -3,6 +3,7 @@ package internal
 import (
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
+	"hash/fnv"
 	"time"
 )
 
@@ -23,15 +24,35 @@ var (
 		},
 		[]string{"code", "ip"},
 	)
+
+	// requestPathBuckets counts requests by a hash of their path, so the spread of paths
+	// requested by an ip can be measured without a label per path.
+	requestPathBuckets = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_request_path_buckets_total",
+			Help: "Counter of requests by hashed path bucket",
+		},
+		[]string{"ip", "bucket"},
+	)
 )
 
+const pathBuckets = 64
+
 func init() {
 	prometheus.MustRegister(requestLatency)
 	prometheus.MustRegister(requestStatusCodes)
+	prometheus.MustRegister(requestPathBuckets)
 }
 
-func ObserveRequestMetrics(start time.Time, statusCode int, ip string) {
+func ObserveRequestMetrics(start time.Time, statusCode int, ip, path string) {
 	duration := time.Since(start)
 	requestLatency.WithLabelValues(ip).Observe(duration.Seconds())
 	requestStatusCodes.WithLabelValues(fmt.Sprint(statusCode), ip).Inc()
+	requestPathBuckets.WithLabelValues(ip, pathBucket(path)).Inc()
+}
+
+func pathBucket(path string) string {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return fmt.Sprint(h.Sum32() % pathBuckets)
 }